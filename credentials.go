@@ -0,0 +1,216 @@
+//   Copyright (c) 2019 cblomart
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	//HubConfigKey is the key of the docker hub in docker config files
+	HubConfigKey = "https://index.docker.io/v1/"
+	// answer of credential helpers without credentials for a registry
+	credentialsNotFound = "credentials not found in native keychain"
+	// username of credentials holding an identity token
+	identityTokenUser = "<token>"
+)
+
+type (
+	//DockerConfig is the credentials part of a docker config.json
+	DockerConfig struct {
+		Auths       map[string]DockerAuth `json:"auths"`
+		CredsStore  string                `json:"credsStore"`
+		CredHelpers map[string]string     `json:"credHelpers"`
+	}
+
+	//DockerAuth is the credentials of a registry in a docker config.json
+	DockerAuth struct {
+		Auth          string `json:"auth"`
+		Username      string `json:"username"`
+		Password      string `json:"password"`
+		IdentityToken string `json:"identitytoken"`
+	}
+
+	// credentials returned by a docker credential helper
+	helperCredentials struct {
+		ServerURL string
+		Username  string
+		Secret    string
+	}
+)
+
+// load the docker config: content of DOCKER_AUTH_CONFIG or PLUGIN_CONFIG, or the config file
+// returns the config (nil if none) and where it was found
+func (p Plugin) loadDockerConfig() (*DockerConfig, string, error) {
+	var data []byte
+	source := p.DockerConfig
+	if len(source) > 0 {
+		/* #nosec */
+		content, err := ioutil.ReadFile(source)
+		if err != nil {
+			return nil, "", fmt.Errorf("cannot read docker config %s", source)
+		}
+		data = content
+	}
+	for _, env := range []string{"DOCKER_AUTH_CONFIG", "PLUGIN_CONFIG"} {
+		if len(data) == 0 && len(os.Getenv(env)) > 0 {
+			data = []byte(os.Getenv(env))
+			source = env
+		}
+	}
+	if len(data) == 0 {
+		dir := os.Getenv("DOCKER_CONFIG")
+		if len(dir) == 0 {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return nil, "", nil
+			}
+			dir = filepath.Join(home, ".docker")
+		}
+		source = filepath.Join(dir, "config.json")
+		/* #nosec */
+		content, err := ioutil.ReadFile(source)
+		if err != nil {
+			// no docker config
+			return nil, "", nil
+		}
+		data = content
+	}
+	var config DockerConfig
+	err := json.Unmarshal(data, &config)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid docker config %s: %s", source, err)
+	}
+	return &config, source, nil
+}
+
+// resolve the credentials of the registry from the docker config
+// credential helpers of the registry have precedence over auths and the credentials store
+func (p *Plugin) dockerCredentials() error {
+	config, source, err := p.loadDockerConfig()
+	if err != nil || config == nil {
+		return err
+	}
+	host := registryHost(p.Registry)
+	server := host
+	if host == registryHost(HubConfigKey) {
+		server = HubConfigKey
+	}
+	var username, password string
+	for key, helper := range config.CredHelpers {
+		if registryHost(key) == host {
+			username, password, err = credentialHelper(helper, key)
+			if err != nil {
+				return err
+			}
+			source = fmt.Sprintf("docker-credential-%s", helper)
+		}
+	}
+	if len(username) == 0 {
+		for key, auth := range config.Auths {
+			if registryHost(key) != host {
+				continue
+			}
+			username, password, err = auth.credentials()
+			if err != nil {
+				return fmt.Errorf("invalid credentials for %s in %s: %s", key, source, err)
+			}
+			server = key
+		}
+	}
+	if len(username) == 0 && len(config.CredsStore) > 0 {
+		username, password, err = credentialHelper(config.CredsStore, server)
+		if err != nil {
+			return err
+		}
+		source = fmt.Sprintf("docker-credential-%s", config.CredsStore)
+	}
+	if len(username) == 0 {
+		return nil
+	}
+	if username == identityTokenUser {
+		// the identity token replaces the credentials to get the registry tokens
+		p.Username = ""
+		p.identityToken = password
+	} else {
+		p.Username = username
+		p.Password = password
+	}
+	if p.Verbose {
+		fmt.Printf("using credentials of %s from %s\n", host, source)
+	}
+	return nil
+}
+
+// get the username and password of docker config credentials
+func (a DockerAuth) credentials() (string, string, error) {
+	if len(a.IdentityToken) > 0 {
+		return identityTokenUser, a.IdentityToken, nil
+	}
+	if len(a.Auth) == 0 {
+		return a.Username, a.Password, nil
+	}
+	decoded, err := base64.StdEncoding.DecodeString(a.Auth)
+	if err != nil {
+		return "", "", fmt.Errorf("auth is not base64 encoded")
+	}
+	parts := strings.SplitN(string(decoded), ":", 2)
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("auth is not in username:password format")
+	}
+	return parts[0], parts[1], nil
+}
+
+// get credentials from a docker credential helper (docker-credential-<helper> get)
+func credentialHelper(helper string, server string) (string, string, error) {
+	/* #nosec */
+	cmd := exec.Command(fmt.Sprintf("docker-credential-%s", helper), "get")
+	cmd.Stdin = strings.NewReader(server)
+	out, err := cmd.Output()
+	if err != nil {
+		if strings.Contains(string(out), credentialsNotFound) {
+			return "", "", nil
+		}
+		return "", "", fmt.Errorf("credential helper %s failed for %s: %s", helper, server, err)
+	}
+	var creds helperCredentials
+	err = json.Unmarshal(out, &creds)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid answer of credential helper %s", helper)
+	}
+	return creds.Username, creds.Secret, nil
+}
+
+// get the host of a registry as used to match docker config keys, docker hub aliases are unified
+func registryHost(registry string) string {
+	host := strings.ToLower(registry)
+	if i := strings.Index(host, "://"); i >= 0 {
+		host = host[i+3:]
+	}
+	if i := strings.Index(host, "/"); i >= 0 {
+		host = host[:i]
+	}
+	if isHubHost(host) {
+		return "docker.io"
+	}
+	return host
+}
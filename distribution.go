@@ -0,0 +1,415 @@
+//   Copyright (c) 2019 cblomart
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cblomart/registry-cleanup/responses/registry"
+	"github.com/cblomart/registry-cleanup/rest"
+)
+
+type (
+	// provider of the registry v2 api (distribution)
+	distributionProvider struct {
+		p Plugin
+		// clients authenticated per repository
+		clients map[string]*distributionClient
+		lock    sync.Mutex
+	}
+
+	// client authenticated on a scope and the base url of the api
+	distributionClient struct {
+		r       *rest.Client
+		baseurl string
+	}
+
+	// result of the inspection of a tag
+	inspection struct {
+		tag Tag
+		// the digest of the tag is known
+		resolved bool
+		// the creation time of the tag is known
+		detailed bool
+	}
+)
+
+// create the registry v2 provider, clients are authenticated on first use of a repository
+func newDistributionProvider(p Plugin) *distributionProvider {
+	return &distributionProvider{p: p, clients: map[string]*distributionClient{}}
+}
+
+//Name of the provider
+func (d *distributionProvider) Name() string {
+	return ProviderRegistry
+}
+
+//Capabilities of the registry v2 api: images are deleted per digest, the digest of every tag is known
+func (d *distributionProvider) Capabilities() Capabilities {
+	return Capabilities{DeleteDigest: true, References: true}
+}
+
+// get the client of a repository
+func (d *distributionProvider) client(repo string) (*distributionClient, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if c, ok := d.clients[repo]; ok {
+		return c, nil
+	}
+	p := d.p
+	p.Repo = repo
+	r, baseurl, err := p.registryClient(fmt.Sprintf("repository:%s:%s", repo, registry.Scope))
+	if err != nil {
+		return nil, err
+	}
+	c := &distributionClient{r: r, baseurl: baseurl}
+	d.clients[repo] = c
+	return c, nil
+}
+
+//Repositories lists the repositories of the catalog matching the repository pattern
+func (d *distributionProvider) Repositories() ([]string, error) {
+	p := d.p
+	r, baseurl, err := p.registryClient(registry.CatalogScope)
+	if err != nil {
+		return nil, err
+	}
+	var repos []string
+	page := fmt.Sprintf("%s_catalog", baseurl)
+	if p.PageSize > 0 {
+		page = fmt.Sprintf("%s?n=%d", page, p.PageSize)
+	}
+	// loop trought the catalog pages
+	for len(page) > 0 {
+		var catalog registry.CatalogResp
+		headers, err := r.GetWithHeaders(p.ctx, page, nil, &catalog)
+		if err != nil {
+			return nil, fmt.Errorf("could not get catalog: %s", p.describe(err))
+		}
+		for _, repo := range catalog.Repositories {
+			if p.matchRepo(repo) {
+				repos = append(repos, repo)
+			}
+		}
+		page = rest.NextLink(headers, page)
+	}
+	if p.Verbose {
+		fmt.Printf("found %d repositories matching %s\n", len(repos), p.RepoPattern)
+	}
+	return repos, nil
+}
+
+//Tags lists the tags of a repository with the digest of all tags and the details of the scoped tags
+func (d *distributionProvider) Tags(repo string, scoped func(string) bool) ([]Tag, map[string][]string, error) {
+	p := d.p
+	p.Repo = repo
+	c, err := d.client(repo)
+	if err != nil {
+		return nil, nil, err
+	}
+	// get the tags list
+	tags, err := p.listTags(c.r, c.baseurl)
+	if err != nil {
+		return nil, nil, err
+	}
+	// filter tags list
+	scopedTags := map[string]bool{}
+	for _, tag := range tags {
+		if scoped(tag) {
+			scopedTags[tag] = true
+		}
+	}
+	if p.Verbose {
+		fmt.Printf("found %d tags/images\n", len(scopedTags))
+	}
+	// get digests of all tags and informations on scoped tags
+	tagInfos, references, err := p.inspect(c.r, c.baseurl, tags, scopedTags)
+	if err != nil {
+		return nil, nil, err
+	}
+	// indicate the details found
+	if p.Verbose {
+		fmt.Printf("found details on %d tags/images\n", len(tagInfos))
+	}
+	return tagInfos, references, nil
+}
+
+//Delete deletes an image per digest
+func (d *distributionProvider) Delete(repo string, image Image) error {
+	c, err := d.client(repo)
+	if err != nil {
+		return err
+	}
+	return c.r.Delete(d.p.deleteCtx, fmt.Sprintf("%s%s/manifests/%s", c.baseurl, repo, image.Digest), nil, nil)
+}
+
+// get a client authenticated on a private registry for a scope and the base url of the api
+func (p Plugin) registryClient(scope string) (*rest.Client, string, error) {
+	// set the base url
+	baseurl := fmt.Sprintf("%s/v2/", p.Registry)
+	// initialize rest client
+	r := p.newClient()
+	// check v2
+	var headers map[string][]string
+	err := r.Head(p.ctx, baseurl, nil, &headers)
+	// the challenges come with an unauthorized response
+	if err != nil && !rest.IsStatus(err, http.StatusUnauthorized) {
+		return nil, "", fmt.Errorf("%s does not support registry v2", p.Registry)
+	}
+	// authenticate following the challenges of the registry
+	challenges, err := rest.ParseChallenges(headers[registry.AuthHeader])
+	if err != nil {
+		return nil, "", err
+	}
+	if len(challenges) > 0 && len(p.Password) == 0 && len(p.identityToken) == 0 {
+		return nil, "", fmt.Errorf("registry %s requires credentials", p.Registry)
+	}
+	userpass := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s", p.Username, p.Password)))
+	if challenge, ok := rest.FindChallenge(challenges, "Bearer"); ok {
+		// get the tokens from the authorization service
+		realm := challenge.Parameters["realm"]
+		if len(realm) == 0 {
+			return nil, "", fmt.Errorf("no realm in bearer challenge")
+		}
+		r.Bearer = &rest.Bearer{Tokens: p.tokens, Realm: realm, Service: challenge.Parameters["service"], Scope: scope}
+		_, err = r.Bearer.Token(p.ctx)
+		if err != nil {
+			return nil, "", fmt.Errorf("could not get token: %s", p.describe(err))
+		}
+		if p.Verbose {
+			fmt.Printf("authenticated with %s\n", p.Username)
+		}
+	} else if _, ok := rest.FindChallenge(challenges, "Basic"); ok {
+		if len(p.Password) == 0 {
+			return nil, "", fmt.Errorf("registry %s does not accept identity tokens (basic)", p.Registry)
+		}
+		// use the credentials directly
+		r.Headers["Authorization"] = fmt.Sprintf("Basic %s", userpass)
+		if p.Verbose {
+			fmt.Printf("authenticated with %s (basic)\n", p.Username)
+		}
+	} else if len(challenges) > 0 {
+		return nil, "", fmt.Errorf("unsupported authentication scheme %s", challenges[0].Scheme)
+	} else if p.Verbose {
+		fmt.Println("registry allows anonymous access")
+	}
+	// set mime type for manifests
+	r.Headers["Accept"] = registry.ManifestAccept
+	return r, baseurl, nil
+}
+
+// list the tags of the repository following the pages of the registry
+func (p Plugin) listTags(r *rest.Client, baseurl string) ([]string, error) {
+	var tags []string
+	page := fmt.Sprintf("%s%s/tags/list", baseurl, p.Repo)
+	if p.PageSize > 0 {
+		page = fmt.Sprintf("%s?n=%d", page, p.PageSize)
+	}
+	// loop trought the result pages
+	for len(page) > 0 {
+		var tagslist registry.TagsListResp
+		headers, err := r.GetWithHeaders(p.ctx, page, nil, &tagslist)
+		if err != nil {
+			return nil, fmt.Errorf("could not get tag list: %s", p.describe(err))
+		}
+		tags = append(tags, tagslist.Tags...)
+		page = rest.NextLink(headers, page)
+	}
+	return tags, nil
+}
+
+// get the digests of all tags and the details of the scoped tags
+// returns the scoped tags details and the tags referencing each digest
+func (p Plugin) inspect(r *rest.Client, baseurl string, tags []string, scopedTags map[string]bool) ([]Tag, map[string][]string, error) {
+	var tagInfos []Tag
+	references := map[string][]string{}
+	unresolved := 0
+	// collect the results of the workers
+	results := make(chan inspection)
+	collected := make(chan struct{})
+	go func() {
+		defer close(collected)
+		for result := range results {
+			if !result.resolved {
+				unresolved++
+				continue
+			}
+			references[result.tag.Digest] = append(references[result.tag.Digest], result.tag.Name)
+			if result.detailed {
+				tagInfos = append(tagInfos, result.tag)
+			}
+		}
+	}()
+	notStarted := parallel(p.ctx, p.Concurrency, len(tags), func(i int) {
+		results <- p.inspectTag(r, baseurl, tags[i], scopedTags[tags[i]])
+	})
+	close(results)
+	<-collected
+	unresolved += len(notStarted)
+	// without all the digests shared images cannot be protected
+	if unresolved > 0 {
+		return nil, nil, fmt.Errorf("could not resolve the digest of %d tags", unresolved)
+	}
+	// results come in completion order
+	sort.Slice(tagInfos, func(i, j int) bool { return tagInfos[i].Name < tagInfos[j].Name })
+	for _, tags := range references {
+		sort.Strings(tags)
+	}
+	return tagInfos, references, nil
+}
+
+// get the digest of a tag and its details if requested
+func (p Plugin) inspectTag(r *rest.Client, baseurl string, tag string, detail bool) inspection {
+	result := inspection{tag: Tag{Name: tag}}
+	// check version of the manifest
+	var headers map[string][]string
+	err := r.Head(p.ctx, fmt.Sprintf("%s%s/manifests/%s", baseurl, p.Repo, tag), nil, &headers)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "could not head manifest %s: %s\n", tag, p.describe(err))
+		return result
+	}
+	// get the digest from headers
+	if digests, ok := headers[registry.DigestHeader]; ok {
+		result.tag.Digest = digests[0]
+	}
+	if len(result.tag.Digest) == 0 {
+		fmt.Fprintf(os.Stderr, "no digest for manifest: %s\n", tag)
+		return result
+	}
+	result.resolved = true
+	// only scoped tags need details
+	if !detail {
+		return result
+	}
+	// check manifest in function of version
+	mimetype, ok := headers["Content-Type"]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "no content type for manifest: %s\n", tag)
+		return result
+	}
+	result.tag.Created, err = p.created(r, baseurl, tag, mimetype[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "could not get creation time for %s: %s\n", tag, err)
+		return result
+	}
+	result.detailed = true
+	return result
+}
+
+// get the creation time of a manifest in function of its mime type
+func (p Plugin) created(r *rest.Client, baseurl string, reference string, mimetype string) (time.Time, error) {
+	switch mimetype {
+	case registry.ManifestMimeV2:
+		var manifest registry.ManifestRespV2
+		err := r.Get(p.ctx, fmt.Sprintf("%s%s/manifests/%s", baseurl, p.Repo, reference), nil, &manifest)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("could not get manifest: %s", err)
+		}
+		return p.configCreated(r, baseurl, manifest.Config.Digest)
+	case registry.ManifestMimeOCI:
+		var manifest registry.ManifestRespOCI
+		err := r.Get(p.ctx, fmt.Sprintf("%s%s/manifests/%s", baseurl, p.Repo, reference), nil, &manifest)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("could not get manifest: %s", err)
+		}
+		return p.configCreated(r, baseurl, manifest.Config.Digest)
+	case registry.IndexMimeOCI:
+		var index registry.IndexRespOCI
+		err := r.Get(p.ctx, fmt.Sprintf("%s%s/manifests/%s", baseurl, p.Repo, reference), nil, &index)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("could not get index: %s", err)
+		}
+		return p.newestCreated(r, baseurl, reference, index.Manifests)
+	case registry.ManifestListMimeV2:
+		var list registry.ManifestListRespV2
+		err := r.Get(p.ctx, fmt.Sprintf("%s%s/manifests/%s", baseurl, p.Repo, reference), nil, &list)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("could not get manifest list: %s", err)
+		}
+		return p.newestCreated(r, baseurl, reference, list.Manifests)
+	case registry.ManifestMimeV1:
+		// get the manifest
+		var manifest registry.ManifestRespV1
+		err := r.Get(p.ctx, fmt.Sprintf("%s%s/manifests/%s", baseurl, p.Repo, reference), nil, &manifest)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("could not get manifest: %s", err)
+		}
+		// get all images informations and check for the latest
+		images := make([]registry.Image, len(manifest.History))
+		latest := -1
+		for i, h := range manifest.History {
+			err = json.Unmarshal([]byte(h.V1Compatibility), &images[i])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "could not decode image from history: %s\n", err)
+				continue
+			}
+			if latest == -1 {
+				latest = i
+				continue
+			}
+			if images[i].Created.After(images[latest].Created) {
+				latest = i
+			}
+		}
+		if latest == -1 {
+			return time.Time{}, fmt.Errorf("no image in history of %s", reference)
+		}
+		return images[latest].Created, nil
+	}
+	return time.Time{}, fmt.Errorf("manifest type not handled: %s", mimetype)
+}
+
+// get the creation time of the newest platform image of a list or an index
+func (p Plugin) newestCreated(r *rest.Client, baseurl string, reference string, manifests []registry.ManifestInfo) (time.Time, error) {
+	var latest time.Time
+	found := false
+	for _, child := range manifests {
+		if child.IsAttestation() {
+			continue
+		}
+		created, err := p.created(r, baseurl, child.Digest, child.MediaType)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if p.Verbose {
+			fmt.Printf("platform %s/%s of %s created %s\n", child.Platform.OS, child.Platform.Architecture, reference, created.Format(time.RFC822))
+		}
+		if !found || created.After(latest) {
+			latest = created
+			found = true
+		}
+	}
+	if !found {
+		return time.Time{}, fmt.Errorf("no platform image in %s", reference)
+	}
+	return latest, nil
+}
+
+// get the creation time from the image config blob
+func (p Plugin) configCreated(r *rest.Client, baseurl string, digest string) (time.Time, error) {
+	var image registry.Image
+	err := r.Get(p.ctx, fmt.Sprintf("%s%s/blobs/%s", baseurl, p.Repo, digest), nil, &image)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("could not get config blob: %s", err)
+	}
+	return image.Created, nil
+}
@@ -0,0 +1,125 @@
+//   Copyright (c) 2019 cblomart
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// time bucket of grandfather-father-son retention
+type bucket struct {
+	name  string
+	count int
+	// start of the period containing a time
+	start func(t time.Time) time.Time
+	// start of the period n periods before the one starting at t
+	back func(t time.Time, n int) time.Time
+	// label of the period starting at t
+	label func(t time.Time) string
+}
+
+// check if the rule uses time buckets
+func (r Rule) isGFS() bool {
+	return r.KeepHourly > 0 || r.KeepDaily > 0 || r.KeepWeekly > 0 || r.KeepMonthly > 0
+}
+
+// get the time buckets of the rule from the finest to the coarsest
+func (r Rule) buckets() []bucket {
+	return []bucket{
+		{
+			name:  "hourly",
+			count: r.KeepHourly,
+			start: func(t time.Time) time.Time { return t.Truncate(time.Hour) },
+			back:  func(t time.Time, n int) time.Time { return t.Add(-time.Duration(n) * time.Hour) },
+			label: func(t time.Time) string { return t.Format("2006-01-02T15") },
+		},
+		{
+			name:  "daily",
+			count: r.KeepDaily,
+			start: func(t time.Time) time.Time { return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC) },
+			back:  func(t time.Time, n int) time.Time { return t.AddDate(0, 0, -n) },
+			label: func(t time.Time) string { return t.Format("2006-01-02") },
+		},
+		{
+			name:  "weekly",
+			count: r.KeepWeekly,
+			start: func(t time.Time) time.Time {
+				day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+				// weeks start on monday
+				return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+			},
+			back: func(t time.Time, n int) time.Time { return t.AddDate(0, 0, -7*n) },
+			label: func(t time.Time) string {
+				year, week := t.ISOWeek()
+				return fmt.Sprintf("%d-W%02d", year, week)
+			},
+		},
+		{
+			name:  "monthly",
+			count: r.KeepMonthly,
+			start: func(t time.Time) time.Time { return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC) },
+			back:  func(t time.Time, n int) time.Time { return t.AddDate(0, -n, 0) },
+			label: func(t time.Time) string { return t.Format("2006-01") },
+		},
+	}
+}
+
+// select the images to keep and to delete: the newest image of each hour, day, week and month
+// of the configured periods is kept as well as the keep newest images and images newer than max age
+func (r Rule) selectBuckets(images []Image) ([]Image, []Image) {
+	now := time.Now().UTC()
+	// buckets that retained each image
+	retained := make([][]string, len(images))
+	for _, b := range r.buckets() {
+		if b.count == 0 {
+			continue
+		}
+		// periods older than the oldest one are not retained
+		oldest := b.back(b.start(now), b.count-1)
+		seen := map[time.Time]bool{}
+		// images are ordered newer to older: the first one of a period is its newest
+		for i, image := range images {
+			start := b.start(image.Created.UTC())
+			if start.Before(oldest) {
+				break
+			}
+			if seen[start] {
+				continue
+			}
+			seen[start] = true
+			retained[i] = append(retained[i], fmt.Sprintf("%s %s", b.name, b.label(start)))
+		}
+	}
+	var kept, deletions []Image
+	treshold := now.Add(-time.Duration(r.MaxAge))
+	for i, image := range images {
+		switch {
+		case len(retained[i]) > 0:
+			image.Reason = strings.Join(retained[i], ", ")
+		case i < r.Keep:
+			image.Reason = fmt.Sprintf("within the %d newest", r.Keep)
+		case r.MaxAge > 0 && !image.Created.Before(treshold):
+			image.Reason = fmt.Sprintf("newer than %s", time.Duration(r.MaxAge))
+		default:
+			image.Reason = "not retained by any bucket"
+			deletions = append(deletions, image)
+			continue
+		}
+		kept = append(kept, image)
+	}
+	return kept, deletions
+}
@@ -0,0 +1,272 @@
+//   Copyright (c) 2019 cblomart
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/cblomart/registry-cleanup/responses/github"
+	"github.com/cblomart/registry-cleanup/responses/registry"
+	"github.com/cblomart/registry-cleanup/rest"
+)
+
+const (
+	//DefaultGitHubAPI is the default url of the github api
+	DefaultGitHubAPI = "https://api.github.com"
+	//GHCRHost is the host of the github container registry
+	GHCRHost = "ghcr.io"
+	//GitHubPageSize is the page size of the github api when not configured (maximum 100)
+	GitHubPageSize = 100
+)
+
+// provider of the github container registry through the packages api
+type ghcrProvider struct {
+	p       Plugin
+	r       *rest.Client
+	baseurl string
+	// api path of the owners (orgs/<owner> or users/<owner>)
+	owners map[string]string
+	lock   sync.Mutex
+	// ids of the versions
+	versions listing
+}
+
+// create the github container registry provider, authenticated with the token in the password
+func newGHCRProvider(p Plugin) *ghcrProvider {
+	api := p.GitHubAPI
+	if len(api) == 0 {
+		api = DefaultGitHubAPI
+	}
+	r := p.newClient()
+	r.Headers["Authorization"] = fmt.Sprintf("Bearer %s", p.Password)
+	r.Headers["Accept"] = "application/vnd.github+json"
+	r.Headers["X-GitHub-Api-Version"] = "2022-11-28"
+	return &ghcrProvider{
+		p:       p,
+		r:       r,
+		baseurl: fmt.Sprintf("%s/", strings.TrimSuffix(api, "/")),
+		owners:  map[string]string{},
+	}
+}
+
+//Name of the provider
+func (g *ghcrProvider) Name() string {
+	return ProviderGHCR
+}
+
+//Capabilities of the github container registry: versions are deleted with all their tags, the tags of every version are known
+func (g *ghcrProvider) Capabilities() Capabilities {
+	return Capabilities{DeleteDigest: true, References: true}
+}
+
+// get the api path of an owner following its type
+func (g *ghcrProvider) owner(name string) (string, error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if path, ok := g.owners[name]; ok {
+		return path, nil
+	}
+	var owner github.Owner
+	err := g.r.Get(g.p.ctx, fmt.Sprintf("%susers/%s", g.baseurl, url.PathEscape(name)), nil, &owner)
+	if err != nil {
+		return "", fmt.Errorf("cannot get owner %s: %s", name, g.p.describe(err))
+	}
+	path := fmt.Sprintf("users/%s", url.PathEscape(name))
+	if owner.Type == "Organization" {
+		path = fmt.Sprintf("orgs/%s", url.PathEscape(name))
+	}
+	g.owners[name] = path
+	return path, nil
+}
+
+// get the url of the package of a repository (<owner>/<package>)
+func (g *ghcrProvider) packageURL(repo string) (string, error) {
+	parts := strings.SplitN(repo, "/", 2)
+	if len(parts) != 2 {
+		return "", fmt.Errorf("repository %s has no owner", repo)
+	}
+	owner, err := g.owner(parts[0])
+	if err != nil {
+		return "", err
+	}
+	// slashes of the package name are escaped
+	return fmt.Sprintf("%s%s/packages/container/%s", g.baseurl, owner, url.PathEscape(parts[1])), nil
+}
+
+// get the url of the first page of a list
+func (g *ghcrProvider) page(list string) string {
+	return firstPage(list, "per_page", g.p.PageSize, GitHubPageSize)
+}
+
+//Repositories lists the container packages of the owner (namespace) matching the repository pattern
+func (g *ghcrProvider) Repositories() ([]string, error) {
+	p := g.p
+	owner, err := g.owner(p.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	var repos []string
+	page := g.page(fmt.Sprintf("%s%s/packages?package_type=container", g.baseurl, owner))
+	// loop trought the result pages
+	for len(page) > 0 {
+		var packages []github.Package
+		headers, err := g.r.GetWithHeaders(p.ctx, page, nil, &packages)
+		if err != nil {
+			return nil, fmt.Errorf("cannot get package page: %s", p.describe(err))
+		}
+		for _, pkg := range packages {
+			name := fmt.Sprintf("%s/%s", p.Namespace, pkg.Name)
+			if len(p.RepoPattern) > 0 && !p.matchRepo(name) {
+				continue
+			}
+			repos = append(repos, name)
+		}
+		page = rest.NextLink(headers, page)
+	}
+	if p.Verbose {
+		fmt.Printf("found %d repositories in %s\n", len(repos), p.Namespace)
+	}
+	return repos, nil
+}
+
+//Tags lists the tags of the versions of a package, aged by their creation
+//untagged versions not referenced by a tagged index are listed under their digest when enabled
+func (g *ghcrProvider) Tags(repo string, scoped func(string) bool) ([]Tag, map[string][]string, error) {
+	p := g.p
+	p.Repo = repo
+	pkg, err := g.packageURL(repo)
+	if err != nil {
+		return nil, nil, err
+	}
+	var tags []Tag
+	references := map[string][]string{}
+	ids := map[string]int64{}
+	var tagged []string
+	var untagged []Tag
+	page := g.page(fmt.Sprintf("%s/versions", pkg))
+	// loop trought the result pages
+	for len(page) > 0 {
+		var versions []github.Version
+		headers, err := g.r.GetWithHeaders(p.ctx, page, nil, &versions)
+		if err != nil {
+			return nil, nil, fmt.Errorf("cannot get version page: %s", p.describe(err))
+		}
+		for _, version := range versions {
+			ids[version.Name] = version.ID
+			created := version.CreatedAt
+			if created.IsZero() {
+				created = version.UpdatedAt
+			}
+			names := version.Metadata.Container.Tags
+			if len(names) == 0 {
+				untagged = append(untagged, Tag{Name: version.Name, Created: created, Digest: version.Name})
+				continue
+			}
+			tagged = append(tagged, version.Name)
+			for _, name := range names {
+				tags = append(tags, Tag{Name: name, Created: created, Digest: version.Name})
+				references[version.Name] = append(references[version.Name], name)
+			}
+		}
+		page = rest.NextLink(headers, page)
+	}
+	if p.Verbose {
+		fmt.Printf("found %d tags/images (%d untagged versions)\n", len(tags), len(untagged))
+	}
+	// untagged versions can be platform images of the tagged indexes
+	if p.Untagged && len(untagged) > 0 {
+		referenced, err := g.referenced(repo, tagged)
+		if err != nil {
+			return nil, nil, err
+		}
+		protected := 0
+		for _, tag := range untagged {
+			if referenced[tag.Digest] {
+				protected++
+				continue
+			}
+			tags = append(tags, tag)
+			references[tag.Digest] = []string{tag.Name}
+		}
+		if p.Verbose {
+			fmt.Printf("%d untagged versions referenced by tagged indexes\n", protected)
+		}
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i].Name < tags[j].Name })
+	for _, names := range references {
+		sort.Strings(names)
+	}
+	listed := map[string]listedImage{}
+	for digest, id := range ids {
+		listed[digest] = listedImage{ID: id, Tags: references[digest]}
+	}
+	g.versions.set(repo, listed)
+	return tags, references, nil
+}
+
+// get the digests of the manifests referenced by the indexes (or manifest lists) of the registry
+func (g *ghcrProvider) referenced(repo string, digests []string) (map[string]bool, error) {
+	p := g.p
+	p.Repo = repo
+	r, baseurl, err := p.registryClient(fmt.Sprintf("repository:%s:pull", repo))
+	if err != nil {
+		return nil, err
+	}
+	// indexes and manifest lists have the same manifests, other manifests none
+	indexes := make([]registry.IndexRespOCI, len(digests))
+	failed := make([]bool, len(digests))
+	notStarted := parallel(p.ctx, p.Concurrency, len(digests), func(i int) {
+		err := r.Get(p.ctx, fmt.Sprintf("%s%s/manifests/%s", baseurl, repo, digests[i]), nil, &indexes[i])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "could not get manifest %s: %s\n", digests[i], err)
+			failed[i] = true
+		}
+	})
+	unresolved := len(notStarted)
+	for _, f := range failed {
+		if f {
+			unresolved++
+		}
+	}
+	// without all the indexes the platform images cannot be protected
+	if unresolved > 0 {
+		return nil, fmt.Errorf("could not read the manifests of %d tagged versions", unresolved)
+	}
+	referenced := map[string]bool{}
+	for _, index := range indexes {
+		for _, manifest := range index.Manifests {
+			referenced[manifest.Digest] = true
+		}
+	}
+	return referenced, nil
+}
+
+//Delete deletes the version of an image
+func (g *ghcrProvider) Delete(repo string, image Image) error {
+	pkg, err := g.packageURL(repo)
+	if err != nil {
+		return err
+	}
+	version, ok := g.versions.get(repo, image.Digest)
+	if !ok {
+		return fmt.Errorf("no version of %s for %s", repo, image.Digest)
+	}
+	return g.r.Delete(g.p.deleteCtx, fmt.Sprintf("%s/versions/%d", pkg, version.ID), nil, nil)
+}
@@ -0,0 +1,141 @@
+//   Copyright (c) 2019 cblomart
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fake github api and registry: org is an organization, someone a user
+// the versions of the packages are on two pages, sha256:a2 is an index of the untagged sha256:p1
+type fakeGHCR struct {
+	url     string
+	lock    sync.Mutex
+	deleted []string
+}
+
+func (f *fakeGHCR) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer secret" && !strings.HasPrefix(r.URL.Path, "/v2/") {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	path := r.URL.EscapedPath()
+	switch {
+	case path == "/users/org":
+		fmt.Fprint(w, `{"login":"org","type":"Organization"}`)
+	case path == "/users/someone":
+		fmt.Fprint(w, `{"login":"someone","type":"User"}`)
+	case r.Method == http.MethodGet && (path == "/orgs/org/packages/container/team%2Fapp/versions" || path == "/users/someone/packages/container/app/versions"):
+		if r.URL.Query().Get("page") == "1" {
+			w.Header().Set("Link", fmt.Sprintf(`<%s%s?per_page=100&page=2>; rel="next"`, f.url, path))
+			fmt.Fprint(w, `[{"id":1,"name":"sha256:a1","created_at":"2020-01-01T00:00:00Z","metadata":{"container":{"tags":["v1"]}}},
+				{"id":2,"name":"sha256:a2","created_at":"2020-01-02T00:00:00Z","metadata":{"container":{"tags":["v2","latest"]}}}]`)
+			return
+		}
+		fmt.Fprint(w, `[{"id":3,"name":"sha256:a3","created_at":"2020-01-03T00:00:00Z","metadata":{"container":{"tags":[]}}},
+			{"id":4,"name":"sha256:p1","created_at":"2020-01-02T00:00:00Z","metadata":{"container":{"tags":[]}}}]`)
+	case path == "/v2/":
+		w.WriteHeader(http.StatusOK)
+	case path == "/v2/org/team/app/manifests/sha256:a1":
+		fmt.Fprint(w, `{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json","config":{"digest":"sha256:c1"}}`)
+	case path == "/v2/org/team/app/manifests/sha256:a2":
+		fmt.Fprint(w, `{"schemaVersion":2,"mediaType":"application/vnd.oci.image.index.v1+json","manifests":[{"digest":"sha256:p1"}]}`)
+	case r.Method == http.MethodDelete:
+		f.lock.Lock()
+		f.deleted = append(f.deleted, path)
+		f.lock.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// start a fake github container registry and its provider
+func newTestGHCR(t *testing.T, untagged bool) (*fakeGHCR, *ghcrProvider) {
+	fake := &fakeGHCR{}
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+	fake.url = srv.URL
+	p := newTestPlugin(t, srv.URL, ProviderGHCR)
+	p.GitHubAPI = srv.URL
+	p.Untagged = untagged
+	return fake, newGHCRProvider(*p)
+}
+
+// names and digests of tags
+func tagNames(tags []Tag) []string {
+	var names []string
+	for _, tag := range tags {
+		names = append(names, fmt.Sprintf("%s@%s", tag.Name, tag.Digest))
+	}
+	return names
+}
+
+func TestGHCRTags(t *testing.T) {
+	_, g := newTestGHCR(t, false)
+	expected := []string{"latest@sha256:a2", "v1@sha256:a1", "v2@sha256:a2"}
+	// organizations and users have their own packages api
+	for _, repo := range []string{"org/team/app", "someone/app"} {
+		tags, references, err := g.Tags(repo, func(string) bool { return true })
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(tagNames(tags), expected) {
+			t.Errorf("listed %v in %s, expected %v", tagNames(tags), repo, expected)
+		}
+		if _, ok := references["sha256:a3"]; ok {
+			t.Errorf("untagged version listed in %s without untagged", repo)
+		}
+	}
+}
+
+func TestGHCRUntagged(t *testing.T) {
+	_, g := newTestGHCR(t, true)
+	tags, _, err := g.Tags("org/team/app", func(string) bool { return true })
+	if err != nil {
+		t.Fatal(err)
+	}
+	// sha256:p1 is a platform image of the index tagged v2
+	expected := []string{"latest@sha256:a2", "sha256:a3@sha256:a3", "v1@sha256:a1", "v2@sha256:a2"}
+	if !reflect.DeepEqual(tagNames(tags), expected) {
+		t.Errorf("listed %v, expected %v", tagNames(tags), expected)
+	}
+}
+
+func TestGHCRDelete(t *testing.T) {
+	fake, g := newTestGHCR(t, false)
+	err := g.Delete("org/team/app", Image{Digest: "sha256:a2", Tags: []string{"latest", "v2"}})
+	if err == nil {
+		t.Error("deleted a version that was not listed")
+	}
+	_, _, err = g.Tags("org/team/app", func(string) bool { return true })
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = g.Delete("org/team/app", Image{Digest: "sha256:a2", Tags: []string{"latest", "v2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"/orgs/org/packages/container/team%2Fapp/versions/2"}
+	if !reflect.DeepEqual(fake.deleted, expected) {
+		t.Errorf("deleted %v, expected %v", fake.deleted, expected)
+	}
+}
@@ -0,0 +1,288 @@
+//   Copyright (c) 2019 cblomart
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/cblomart/registry-cleanup/responses/gitlab"
+	"github.com/cblomart/registry-cleanup/rest"
+)
+
+const (
+	//DefaultGitLabAPI is the default url of the gitlab api
+	DefaultGitLabAPI = "https://gitlab.com/api/v4"
+	//GitLabRegistryHost is the host of the gitlab.com container registry
+	GitLabRegistryHost = "registry.gitlab.com"
+	//GitLabPageSize is the page size of the gitlab api when not configured (maximum 100)
+	GitLabPageSize = 100
+)
+
+// provider of the gitlab container registry api
+type gitlabProvider struct {
+	p       Plugin
+	r       *rest.Client
+	baseurl string
+	// registry repositories per path
+	repositories map[string]gitlab.Repository
+	lock         sync.Mutex
+}
+
+// create the gitlab provider, authenticated with the access token in the password or the job token
+func newGitLabProvider(p Plugin) *gitlabProvider {
+	api := p.GitLabAPI
+	if len(api) == 0 {
+		api = DefaultGitLabAPI
+	}
+	r := p.newClient()
+	if len(p.Password) > 0 {
+		r.Headers["PRIVATE-TOKEN"] = p.Password
+	} else {
+		r.Headers["JOB-TOKEN"] = p.JobToken
+	}
+	return &gitlabProvider{p: p, r: r, baseurl: fmt.Sprintf("%s/", strings.TrimSuffix(api, "/")), repositories: map[string]gitlab.Repository{}}
+}
+
+// check if a registry is the registry of the gitlab ci job
+func isGitLabCIRegistry(registry string) bool {
+	ci := os.Getenv("CI_REGISTRY")
+	if len(ci) == 0 {
+		return false
+	}
+	normalized, err := normalizeRegistry(ci)
+	return err == nil && normalized == registry
+}
+
+// check that the retention rules can be applied by the gitlab bulk delete: regex, min and max only
+func (p Plugin) checkBulk() error {
+	if p.Provider != ProviderGitLab {
+		return fmt.Errorf("bulk delete is only supported on gitlab")
+	}
+	if len(p.Policy) > 0 || p.Semver || p.KeepHourly > 0 || p.KeepDaily > 0 || p.KeepWeekly > 0 || p.KeepMonthly > 0 {
+		return fmt.Errorf("bulk delete only supports regex, min and max")
+	}
+	if p.DryRun || p.planned != nil {
+		return fmt.Errorf("bulk delete cannot be planned or dry run")
+	}
+	re, err := regexp.Compile(p.Regex)
+	if err == nil {
+		for _, name := range re.SubexpNames() {
+			if name == GroupName {
+				return fmt.Errorf("bulk delete does not support retention groups")
+			}
+		}
+	}
+	return nil
+}
+
+//Name of the provider
+func (g *gitlabProvider) Name() string {
+	return ProviderGitLab
+}
+
+//Capabilities of gitlab: deleting a tag deletes its manifest and so all the tags of the image
+func (g *gitlabProvider) Capabilities() Capabilities {
+	return Capabilities{DeleteDigest: true, References: true}
+}
+
+// get the url of the first page of a list
+func (g *gitlabProvider) page(list string) string {
+	return firstPage(list, "per_page", g.p.PageSize, GitLabPageSize)
+}
+
+// list the registry repositories of a group or a project
+func (g *gitlabProvider) list(list string) ([]gitlab.Repository, error) {
+	var repositories []gitlab.Repository
+	page := g.page(list)
+	// loop trought the result pages
+	for len(page) > 0 {
+		var repos []gitlab.Repository
+		headers, err := g.r.GetWithHeaders(g.p.ctx, page, nil, &repos)
+		if err != nil {
+			return nil, err
+		}
+		repositories = append(repositories, repos...)
+		page = rest.NextLink(headers, page)
+	}
+	g.lock.Lock()
+	for _, repo := range repositories {
+		g.repositories[repo.Path] = repo
+	}
+	g.lock.Unlock()
+	return repositories, nil
+}
+
+// get the registry repository of a path, looking for it in the projects of its parent paths
+func (g *gitlabProvider) repository(path string) (gitlab.Repository, error) {
+	g.lock.Lock()
+	repo, ok := g.repositories[path]
+	g.lock.Unlock()
+	if ok {
+		return repo, nil
+	}
+	components := strings.Split(path, "/")
+	// projects are at least in a namespace
+	for i := len(components); i >= 2; i-- {
+		project := strings.Join(components[:i], "/")
+		_, err := g.list(fmt.Sprintf("%sprojects/%s/registry/repositories", g.baseurl, url.PathEscape(project)))
+		if rest.IsStatus(err, http.StatusNotFound) {
+			continue
+		}
+		if err != nil {
+			return repo, fmt.Errorf("cannot get registry repositories of %s: %s", project, g.p.describe(err))
+		}
+		g.lock.Lock()
+		repo, ok = g.repositories[path]
+		g.lock.Unlock()
+		if ok {
+			return repo, nil
+		}
+		break
+	}
+	return repo, fmt.Errorf("no registry repository %s on gitlab", path)
+}
+
+// get the url of the tags of a registry repository
+func (g *gitlabProvider) tagsURL(path string) (string, error) {
+	repo, err := g.repository(path)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%sprojects/%d/registry/repositories/%d/tags", g.baseurl, repo.ProjectID, repo.ID), nil
+}
+
+//Repositories lists the registry repositories of the group (namespace) matching the repository pattern
+func (g *gitlabProvider) Repositories() ([]string, error) {
+	p := g.p
+	repositories, err := g.list(fmt.Sprintf("%sgroups/%s/registry/repositories", g.baseurl, url.PathEscape(p.Namespace)))
+	if err != nil {
+		return nil, fmt.Errorf("cannot get registry repositories of %s: %s", p.Namespace, p.describe(err))
+	}
+	var repos []string
+	for _, repo := range repositories {
+		if len(p.RepoPattern) > 0 && !p.matchRepo(repo.Path) {
+			continue
+		}
+		repos = append(repos, repo.Path)
+	}
+	sort.Strings(repos)
+	if p.Verbose {
+		fmt.Printf("found %d repositories in %s\n", len(repos), p.Namespace)
+	}
+	return repos, nil
+}
+
+//Tags lists the tags of a registry repository with the details of all tags (digest and creation time)
+func (g *gitlabProvider) Tags(repo string, scoped func(string) bool) ([]Tag, map[string][]string, error) {
+	p := g.p
+	p.Repo = repo
+	tagsurl, err := g.tagsURL(repo)
+	if err != nil {
+		return nil, nil, err
+	}
+	var names []string
+	page := g.page(tagsurl)
+	// loop trought the result pages
+	for len(page) > 0 {
+		var tags []gitlab.Tag
+		headers, err := g.r.GetWithHeaders(p.ctx, page, nil, &tags)
+		if err != nil {
+			return nil, nil, fmt.Errorf("cannot get tag page: %s", p.describe(err))
+		}
+		for _, tag := range tags {
+			names = append(names, tag.Name)
+		}
+		page = rest.NextLink(headers, page)
+	}
+	if p.Verbose {
+		fmt.Printf("found %d tags/images\n", len(names))
+	}
+	// the digest of every tag is needed to protect shared images
+	details := make([]gitlab.Tag, len(names))
+	failed := make([]bool, len(names))
+	notStarted := parallel(p.ctx, p.Concurrency, len(names), func(i int) {
+		err := g.r.Get(p.ctx, fmt.Sprintf("%s/%s", tagsurl, url.PathEscape(names[i])), nil, &details[i])
+		if err != nil || len(details[i].Digest) == 0 {
+			fmt.Fprintf(os.Stderr, "could not get details of tag %s: %v\n", names[i], err)
+			failed[i] = true
+		}
+	})
+	unresolved := len(notStarted)
+	for _, f := range failed {
+		if f {
+			unresolved++
+		}
+	}
+	if unresolved > 0 {
+		return nil, nil, fmt.Errorf("could not resolve the digest of %d tags", unresolved)
+	}
+	var tags []Tag
+	references := map[string][]string{}
+	for _, detail := range details {
+		tags = append(tags, Tag{Name: detail.Name, Created: detail.CreatedAt, Digest: detail.Digest})
+		references[detail.Digest] = append(references[detail.Digest], detail.Name)
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i].Name < tags[j].Name })
+	for _, names := range references {
+		sort.Strings(names)
+	}
+	return tags, references, nil
+}
+
+//Delete deletes the tags of an image
+func (g *gitlabProvider) Delete(repo string, image Image) error {
+	tagsurl, err := g.tagsURL(repo)
+	if err != nil {
+		return err
+	}
+	for _, tag := range image.Tags {
+		err := g.r.Delete(g.p.deleteCtx, fmt.Sprintf("%s/%s", tagsurl, url.PathEscape(tag)), nil, nil)
+		// the other tags of the image disappear with the first one
+		if err != nil && !rest.IsStatus(err, http.StatusNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+//BulkDelete schedules the deletion of the tags matching the regex older than the maximum age, keeping the minimum
+func (g *gitlabProvider) BulkDelete(repo string) error {
+	p := g.p
+	p.Repo = repo
+	tagsurl, err := g.tagsURL(repo)
+	if err != nil {
+		return err
+	}
+	query := url.Values{}
+	// gitlab matches the whole tag name
+	query.Set("name_regex_delete", fmt.Sprintf(".*(?:%s).*", p.Regex))
+	query.Set("keep_n", fmt.Sprintf("%d", p.Min))
+	// rounded up to keep the tags at the limit
+	query.Set("older_than", fmt.Sprintf("%dh", int(math.Ceil(p.Max.Hours()))))
+	err = g.r.Delete(p.deleteCtx, fmt.Sprintf("%s?%s", tagsurl, query.Encode()), nil, nil)
+	if err != nil {
+		return fmt.Errorf("cannot schedule bulk delete: %s", p.describe(err))
+	}
+	fmt.Printf("scheduled bulk delete of %s: tags matching %s older than %s keeping %d\n", repo, p.Regex, p.Max, p.Min)
+	return nil
+}
@@ -0,0 +1,149 @@
+//   Copyright (c) 2019 cblomart
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fake gitlab api: the registry repository proj/team/app belongs to the project proj/team
+// its tags are on two pages, v2 and latest share their image
+type fakeGitLab struct {
+	url      string
+	lock     sync.Mutex
+	details  []string
+	deleted  []string
+	bulkSent string
+}
+
+var fakeGitLabDigests = map[string]string{"v1": "sha256:a1", "v2": "sha256:a2", "latest": "sha256:a2", "v3": "sha256:a3"}
+
+func (f *fakeGitLab) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("PRIVATE-TOKEN") != "secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	tags := "/api/v4/projects/3/registry/repositories/7/tags"
+	path := r.URL.EscapedPath()
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	switch {
+	case path == "/api/v4/projects/proj%2Fteam/registry/repositories":
+		fmt.Fprint(w, `[{"id":7,"path":"proj/team/app","project_id":3},{"id":8,"path":"proj/team","project_id":3}]`)
+	case r.Method == http.MethodGet && path == tags && r.URL.Query().Get("page") == "1":
+		w.Header().Set("Link", fmt.Sprintf(`<%s%s?per_page=100&page=2>; rel="next"`, f.url, tags))
+		fmt.Fprint(w, `[{"name":"v1"},{"name":"v2"}]`)
+	case r.Method == http.MethodGet && path == tags:
+		fmt.Fprint(w, `[{"name":"latest"},{"name":"v3"}]`)
+	case r.Method == http.MethodGet && strings.HasPrefix(path, tags+"/"):
+		name := strings.TrimPrefix(path, tags+"/")
+		f.details = append(f.details, name)
+		fmt.Fprintf(w, `{"name":"%s","digest":"%s","created_at":"2020-01-01T00:00:00Z"}`, name, fakeGitLabDigests[name])
+	case r.Method == http.MethodDelete && path == tags:
+		f.bulkSent = r.URL.RawQuery
+		w.WriteHeader(http.StatusAccepted)
+	case r.Method == http.MethodDelete && path == tags+"/latest":
+		// already deleted with v2
+		w.WriteHeader(http.StatusNotFound)
+	case r.Method == http.MethodDelete:
+		f.deleted = append(f.deleted, path)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// start a fake gitlab and its provider
+func newTestGitLab(t *testing.T) (*fakeGitLab, *gitlabProvider) {
+	fake := &fakeGitLab{}
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+	fake.url = srv.URL
+	p := newTestPlugin(t, srv.URL, ProviderGitLab)
+	p.GitLabAPI = srv.URL + "/api/v4"
+	return fake, newGitLabProvider(*p)
+}
+
+func TestGitLabTags(t *testing.T) {
+	fake, g := newTestGitLab(t)
+	tags, references, err := g.Tags("proj/team/app", func(string) bool { return true })
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"latest@sha256:a2", "v1@sha256:a1", "v2@sha256:a2", "v3@sha256:a3"}
+	if !reflect.DeepEqual(tagNames(tags), expected) {
+		t.Errorf("listed %v, expected %v", tagNames(tags), expected)
+	}
+	if !reflect.DeepEqual(references["sha256:a2"], []string{"latest", "v2"}) {
+		t.Errorf("references of sha256:a2 are %v", references["sha256:a2"])
+	}
+	// the digests are only in the details of every tag
+	sort.Strings(fake.details)
+	if !reflect.DeepEqual(fake.details, []string{"latest", "v1", "v2", "v3"}) {
+		t.Errorf("fetched the details of %v", fake.details)
+	}
+}
+
+func TestGitLabDelete(t *testing.T) {
+	fake, g := newTestGitLab(t)
+	err := g.Delete("proj/team/app", Image{Digest: "sha256:a2", Tags: []string{"latest", "v2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"/api/v4/projects/3/registry/repositories/7/tags/v2"}
+	if !reflect.DeepEqual(fake.deleted, expected) {
+		t.Errorf("deleted %v, expected %v", fake.deleted, expected)
+	}
+}
+
+func TestGitLabBulkDelete(t *testing.T) {
+	fake, g := newTestGitLab(t)
+	g.p.Min = 3
+	g.p.Max = 90 * time.Minute
+	err := g.BulkDelete("proj/team/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the whole tag name is matched and the age is rounded up to hours
+	expected := "keep_n=3&name_regex_delete=.%2A%28%3F%3A%5Ev%29.%2A&older_than=2h"
+	if fake.bulkSent != expected {
+		t.Errorf("sent %s, expected %s", fake.bulkSent, expected)
+	}
+}
+
+func TestGitLabJobTokenOnlyOnGitLab(t *testing.T) {
+	// a plain registry without challenge
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+	os.Setenv("DOCKER_AUTH_CONFIG", fmt.Sprintf(`{"auths":{"%s":{"auth":"dXNlcjpzZWNyZXQ="}}}`, strings.TrimPrefix(srv.URL, "http://")))
+	defer os.Unsetenv("DOCKER_AUTH_CONFIG")
+	// the job token of the gitlab ci does not replace the docker config on other registries
+	p := Plugin{Registry: srv.URL, JobToken: "job", Repo: "proj/app", Regex: "^v", Min: 1, Max: time.Hour, Concurrency: 1, DeleteConcurrency: 1}
+	err := p.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Provider != ProviderRegistry || p.Username != "user" || p.Password != "secret" {
+		t.Errorf("provider %s with %s:%s, expected the docker config credentials on %s", p.Provider, p.Username, p.Password, ProviderRegistry)
+	}
+}
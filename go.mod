@@ -5,4 +5,5 @@ go 1.14
 require (
 	github.com/joho/godotenv v1.3.0
 	github.com/urfave/cli v1.22.3
+	gopkg.in/yaml.v3 v3.0.1
 )
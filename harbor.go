@@ -0,0 +1,217 @@
+//   Copyright (c) 2019 cblomart
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/cblomart/registry-cleanup/responses/harbor"
+	"github.com/cblomart/registry-cleanup/rest"
+)
+
+const (
+	//HarborPageSize is the page size of the harbor api when not configured (maximum 100)
+	HarborPageSize = 100
+	//AgePush uses the push time as age of the tags
+	AgePush = "push"
+	//AgePull uses the last pull time as age of the tags (push time when never pulled)
+	AgePull = "pull"
+)
+
+// provider of the harbor v2.0 api
+type harborProvider struct {
+	p       Plugin
+	r       *rest.Client
+	baseurl string
+	// tags of the artifacts
+	artifacts listing
+}
+
+// create the harbor provider, authenticated with the credentials
+func newHarborProvider(p Plugin) *harborProvider {
+	r := p.newClient()
+	userpass := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s", p.Username, p.Password)))
+	r.Headers["Authorization"] = fmt.Sprintf("Basic %s", userpass)
+	return &harborProvider{p: p, r: r, baseurl: harborURL(p.Registry)}
+}
+
+// get the base url of the harbor api of a registry
+func harborURL(registry string) string {
+	return fmt.Sprintf("%s/api/v2.0/", registry)
+}
+
+// check if a registry is a harbor instance from its system informations
+// a single attempt is made: other registries do not have this api
+func (p Plugin) isHarbor() bool {
+	ctx := p.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	r := p.newClient()
+	r.Retry.MaxAttempts = 1
+	if len(p.Password) > 0 {
+		userpass := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s", p.Username, p.Password)))
+		r.Headers["Authorization"] = fmt.Sprintf("Basic %s", userpass)
+	}
+	var info harbor.SystemInfo
+	err := r.Get(ctx, fmt.Sprintf("%ssysteminfo", harborURL(p.Registry)), nil, &info)
+	return err == nil && len(info.HarborVersion) > 0
+}
+
+// check an age source
+func checkAgeSource(source string) error {
+	switch source {
+	case AgePush, AgePull:
+		return nil
+	}
+	return fmt.Errorf("unknown age source %s (%s or %s)", source, AgePush, AgePull)
+}
+
+// split a repository in its project and its name in the project, escaped for the harbor api
+func harborRepository(repo string) (string, string, error) {
+	parts := strings.SplitN(repo, "/", 2)
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("repository %s has no project", repo)
+	}
+	// slashes of the name are escaped twice
+	return url.PathEscape(parts[0]), url.PathEscape(url.PathEscape(parts[1])), nil
+}
+
+//Name of the provider
+func (h *harborProvider) Name() string {
+	return ProviderHarbor
+}
+
+//Capabilities of harbor: artifacts are deleted per digest, the tags of every artifact are known
+func (h *harborProvider) Capabilities() Capabilities {
+	return Capabilities{DeleteDigest: true, References: true}
+}
+
+// get the url of the first page of a list
+func (h *harborProvider) page(path string) string {
+	return firstPage(h.baseurl+path, "page_size", h.p.PageSize, HarborPageSize)
+}
+
+//Repositories lists the repositories of the project (namespace) or of all projects matching the repository pattern
+func (h *harborProvider) Repositories() ([]string, error) {
+	p := h.p
+	page := h.page("repositories")
+	if len(p.Namespace) > 0 {
+		page = h.page(fmt.Sprintf("projects/%s/repositories", url.PathEscape(p.Namespace)))
+	}
+	var repos []string
+	// loop trought the result pages
+	for len(page) > 0 {
+		var repositories []harbor.Repository
+		headers, err := h.r.GetWithHeaders(p.ctx, page, nil, &repositories)
+		if err != nil {
+			return nil, fmt.Errorf("cannot get repository page: %s", p.describe(err))
+		}
+		for _, repo := range repositories {
+			if len(p.RepoPattern) > 0 && !p.matchRepo(repo.Name) {
+				continue
+			}
+			repos = append(repos, repo.Name)
+		}
+		page = rest.NextLink(headers, page)
+	}
+	if p.Verbose {
+		fmt.Printf("found %d repositories\n", len(repos))
+	}
+	return repos, nil
+}
+
+//Tags lists the tags of the artifacts of a repository, aged by their push or last pull time
+//artifacts without tags are ignored
+func (h *harborProvider) Tags(repo string, scoped func(string) bool) ([]Tag, map[string][]string, error) {
+	p := h.p
+	p.Repo = repo
+	project, name, err := harborRepository(repo)
+	if err != nil {
+		return nil, nil, err
+	}
+	var tags []Tag
+	references := map[string][]string{}
+	page := h.page(fmt.Sprintf("projects/%s/repositories/%s/artifacts?with_tag=true", project, name))
+	// loop trought the result pages
+	for len(page) > 0 {
+		var artifacts []harbor.Artifact
+		headers, err := h.r.GetWithHeaders(p.ctx, page, nil, &artifacts)
+		if err != nil {
+			return nil, nil, fmt.Errorf("cannot get artifact page: %s", p.describe(err))
+		}
+		for _, artifact := range artifacts {
+			created := artifact.PushTime
+			if p.AgeSource == AgePull && !artifact.PullTime.IsZero() {
+				created = artifact.PullTime
+			}
+			for _, tag := range artifact.Tags {
+				tags = append(tags, Tag{Name: tag.Name, Created: created, Digest: artifact.Digest})
+				references[artifact.Digest] = append(references[artifact.Digest], tag.Name)
+			}
+		}
+		page = rest.NextLink(headers, page)
+	}
+	if p.Verbose {
+		fmt.Printf("found %d tags/images\n", len(tags))
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i].Name < tags[j].Name })
+	listed := map[string]listedImage{}
+	for digest, names := range references {
+		sort.Strings(names)
+		listed[digest] = listedImage{Tags: names}
+	}
+	h.artifacts.set(repo, listed)
+	return tags, references, nil
+}
+
+//Delete deletes an artifact when the image has all its tags, only the tags of the image otherwise
+func (h *harborProvider) Delete(repo string, image Image) error {
+	project, name, err := harborRepository(repo)
+	if err != nil {
+		return err
+	}
+	artifact := fmt.Sprintf("%sprojects/%s/repositories/%s/artifacts/%s", h.baseurl, project, name, image.Digest)
+	planned := map[string]bool{}
+	for _, tag := range image.Tags {
+		planned[tag] = true
+	}
+	listed, ok := h.artifacts.get(repo, image.Digest)
+	// without listing the other tags of the artifact are unknown
+	if !ok {
+		return fmt.Errorf("artifact %s of %s was not listed", image.Digest, repo)
+	}
+	whole := true
+	for _, tag := range listed.Tags {
+		whole = whole && planned[tag]
+	}
+	if whole {
+		return h.r.Delete(h.p.deleteCtx, artifact, nil, nil)
+	}
+	for _, tag := range image.Tags {
+		err := h.r.Delete(h.p.deleteCtx, fmt.Sprintf("%s/tags/%s", artifact, url.PathEscape(tag)), nil, nil)
+		// a tag already deleted does not prevent the deletion of the others
+		if err != nil && !rest.IsStatus(err, http.StatusNotFound) {
+			return err
+		}
+	}
+	return nil
+}
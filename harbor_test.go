@@ -0,0 +1,142 @@
+//   Copyright (c) 2019 cblomart
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// checked and started plugin of a test registry
+func newTestPlugin(t *testing.T, registry string, provider string) *Plugin {
+	p := &Plugin{
+		Registry:          registry,
+		Provider:          provider,
+		Username:          "user",
+		Password:          "secret",
+		Repo:              "proj/team/app",
+		Regex:             "^v",
+		Min:               1,
+		Max:               time.Hour,
+		Concurrency:       2,
+		DeleteConcurrency: 1,
+	}
+	t.Cleanup(p.start())
+	err := p.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// fake harbor serving the artifacts of proj/team/app on two pages
+type fakeHarbor struct {
+	lock    sync.Mutex
+	deleted []string
+}
+
+func (f *fakeHarbor) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/api/v2.0/systeminfo" {
+		fmt.Fprint(w, `{"harbor_version":"v2.9.0"}`)
+		return
+	}
+	if user, _, _ := r.BasicAuth(); user != "user" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	// the name of the repository in the project is escaped twice
+	artifacts := "/api/v2.0/projects/proj/repositories/team%252Fapp/artifacts"
+	switch {
+	case r.Method == http.MethodGet && r.URL.EscapedPath() == artifacts && r.URL.Query().Get("page") == "1":
+		w.Header().Set("Link", `</api/v2.0/projects/proj/repositories/team%252Fapp/artifacts?page=2&page_size=100&with_tag=true>; rel="next"`)
+		fmt.Fprint(w, `[{"digest":"sha256:a1","push_time":"2020-01-01T00:00:00Z","tags":[{"name":"v1"}]},
+			{"digest":"sha256:a2","push_time":"2020-01-02T00:00:00Z","tags":[{"name":"v2"},{"name":"latest"}]}]`)
+	case r.Method == http.MethodGet && r.URL.EscapedPath() == artifacts && r.URL.Query().Get("page") == "2":
+		fmt.Fprint(w, `[{"digest":"sha256:a3","push_time":"2020-01-03T00:00:00Z","tags":[{"name":"v3"}]}]`)
+	case r.Method == http.MethodDelete:
+		f.lock.Lock()
+		f.deleted = append(f.deleted, r.URL.EscapedPath())
+		f.lock.Unlock()
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestHarborDetection(t *testing.T) {
+	srv := httptest.NewServer(&fakeHarbor{})
+	defer srv.Close()
+	p := newTestPlugin(t, srv.URL, ProviderAuto)
+	if p.Provider != ProviderHarbor {
+		t.Errorf("detected provider %s, expected %s", p.Provider, ProviderHarbor)
+	}
+}
+
+func TestHarborTags(t *testing.T) {
+	srv := httptest.NewServer(&fakeHarbor{})
+	defer srv.Close()
+	h := newHarborProvider(*newTestPlugin(t, srv.URL, ProviderHarbor))
+	tags, references, err := h.Tags("proj/team/app", func(string) bool { return true })
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, tag := range tags {
+		names = append(names, fmt.Sprintf("%s@%s", tag.Name, tag.Digest))
+	}
+	expected := []string{"latest@sha256:a2", "v1@sha256:a1", "v2@sha256:a2", "v3@sha256:a3"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("listed %v, expected %v", names, expected)
+	}
+	if !reflect.DeepEqual(references["sha256:a2"], []string{"latest", "v2"}) {
+		t.Errorf("references of sha256:a2 are %v", references["sha256:a2"])
+	}
+}
+
+func TestHarborDelete(t *testing.T) {
+	fake := &fakeHarbor{}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	h := newHarborProvider(*newTestPlugin(t, srv.URL, ProviderHarbor))
+	// the artifacts must be listed before their deletion
+	err := h.Delete("proj/team/app", Image{Digest: "sha256:a1", Tags: []string{"v1"}})
+	if err == nil {
+		t.Error("deleted an artifact that was not listed")
+	}
+	_, _, err = h.Tags("proj/team/app", func(string) bool { return true })
+	if err != nil {
+		t.Fatal(err)
+	}
+	// an artifact with all its tags is deleted, only the tags otherwise
+	err = h.Delete("proj/team/app", Image{Digest: "sha256:a1", Tags: []string{"v1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = h.Delete("proj/team/app", Image{Digest: "sha256:a2", Tags: []string{"v2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"/api/v2.0/projects/proj/repositories/team%252Fapp/artifacts/sha256:a1",
+		"/api/v2.0/projects/proj/repositories/team%252Fapp/artifacts/sha256:a2/tags/v2",
+	}
+	if !reflect.DeepEqual(fake.deleted, expected) {
+		t.Errorf("deleted %v, expected %v", fake.deleted, expected)
+	}
+}
@@ -0,0 +1,122 @@
+//   Copyright (c) 2019 cblomart
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/cblomart/registry-cleanup/responses/hub"
+	"github.com/cblomart/registry-cleanup/rest"
+)
+
+// provider of the docker hub api
+type hubProvider struct {
+	p       Plugin
+	r       *rest.Client
+	baseurl string
+}
+
+// create the docker hub provider, authenticated with the credentials
+func newHubProvider(p Plugin) (*hubProvider, error) {
+	// get the base url
+	baseurl := fmt.Sprintf("%s/v2/", p.Registry)
+	// initialize rest client
+	r := p.newClient()
+	// get a token
+	var token hub.Token
+	err := r.Post(p.ctx, fmt.Sprintf("%susers/login/", baseurl), map[string]string{"username": p.Username, "password": p.Password}, &token)
+	if err != nil {
+		return nil, fmt.Errorf("could not get token: %s", p.describe(err))
+	}
+	if p.Verbose {
+		fmt.Printf("authenticated with %s\n", p.Username)
+	}
+	r.Headers["Authorization"] = fmt.Sprintf("Bearer %s", token.Token)
+	return &hubProvider{p: p, r: r, baseurl: baseurl}, nil
+}
+
+//Name of the provider
+func (h *hubProvider) Name() string {
+	return ProviderHub
+}
+
+//Capabilities of the docker hub: tags are deleted one by one, no digest to protect
+func (h *hubProvider) Capabilities() Capabilities {
+	return Capabilities{}
+}
+
+//Repositories lists the repositories of the namespace matching the repository pattern
+func (h *hubProvider) Repositories() ([]string, error) {
+	p := h.p
+	var repos []string
+	url := fmt.Sprintf("%srepositories/%s/?page_size=%d&page=%d", h.baseurl, p.Namespace, HubPageSize, 1)
+	// loop trought the result pages
+	for len(url) > 0 {
+		var page hub.Repositories
+		err := h.r.Get(p.ctx, url, nil, &page)
+		if err != nil {
+			return nil, fmt.Errorf("cannot get repository page: %s", p.describe(err))
+		}
+		url = page.Next
+		for _, repo := range page.Results {
+			name := fmt.Sprintf("%s/%s", p.Namespace, repo.Name)
+			if len(p.RepoPattern) > 0 && !p.matchRepo(name) {
+				continue
+			}
+			repos = append(repos, name)
+		}
+	}
+	if p.Verbose {
+		fmt.Printf("found %d repositories in %s\n", len(repos), p.Namespace)
+	}
+	return repos, nil
+}
+
+//Tags lists all the tags of a repository: the tag pages already contain their details
+func (h *hubProvider) Tags(repo string, scoped func(string) bool) ([]Tag, map[string][]string, error) {
+	p := h.p
+	p.Repo = repo
+	var tags []Tag
+	url := fmt.Sprintf("%srepositories/%s/tags/?page_size=%d&page=%d", h.baseurl, repo, HubPageSize, 1)
+	// loop trought the result pages
+	for len(url) > 0 {
+		var tagpage hub.Tags
+		err := h.r.Get(p.ctx, url, nil, &tagpage)
+		if err != nil {
+			return nil, nil, fmt.Errorf("cannot get tag page: %s", p.describe(err))
+		}
+		url = tagpage.Next
+		for _, tag := range tagpage.Results {
+			tags = append(tags, Tag{Name: tag.Name, Created: tag.LastUpdated, Digest: tag.Digest})
+		}
+		if p.Verbose {
+			fmt.Printf("found %d tags/images\n", len(tags))
+		}
+	}
+	return tags, nil, nil
+}
+
+//Delete deletes the tags of an image
+func (h *hubProvider) Delete(repo string, image Image) error {
+	for _, tag := range image.Tags {
+		err := h.r.Delete(h.p.deleteCtx, fmt.Sprintf("%srepositories/%s/tags/%s/", h.baseurl, repo, tag), nil, nil)
+		// a tag already deleted does not prevent the deletion of the others
+		if err != nil && !rest.IsStatus(err, http.StatusNotFound) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+//   Copyright (c) 2019 cblomart
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"sync"
+	"time"
+)
+
+type (
+	//Plan is the list of tags/images to delete written by plan and executed by apply
+	Plan struct {
+		Registry string      `json:"registry"`
+		Planned  time.Time   `json:"planned"`
+		Entries  []PlanEntry `json:"entries"`
+		lock     sync.Mutex
+	}
+
+	//PlanEntry is a tag to delete
+	PlanEntry struct {
+		Repo    string    `json:"repo"`
+		Tag     string    `json:"tag"`
+		Digest  string    `json:"digest,omitempty"`
+		Created time.Time `json:"created"`
+		Reason  string    `json:"reason"`
+	}
+)
+
+// add the tags of images to delete to the plan
+func (pl *Plan) add(repo string, images []Image) {
+	pl.lock.Lock()
+	defer pl.lock.Unlock()
+	for _, image := range images {
+		fmt.Printf("planned [%s] %s:%s (%s)\n", image.Created.Format(time.RFC822), repo, image.Name(), image.Reason)
+		for _, tag := range image.Tags {
+			pl.Entries = append(pl.Entries, PlanEntry{Repo: repo, Tag: tag, Digest: image.Digest, Created: image.Created, Reason: image.Reason})
+		}
+	}
+}
+
+// write the plan to a file
+func (pl *Plan) write(file string) error {
+	sort.SliceStable(pl.Entries, func(i, j int) bool {
+		if pl.Entries[i].Repo != pl.Entries[j].Repo {
+			return pl.Entries[i].Repo < pl.Entries[j].Repo
+		}
+		return pl.Entries[i].Tag < pl.Entries[j].Tag
+	})
+	data, err := json.MarshalIndent(pl, "", "  ")
+	if err != nil {
+		return fmt.Errorf("cannot serialise plan")
+	}
+	err = ioutil.WriteFile(file, data, 0600)
+	if err != nil {
+		return fmt.Errorf("cannot write plan %s", file)
+	}
+	return nil
+}
+
+// read a plan from a file
+func readPlan(file string) (*Plan, error) {
+	/* #nosec */
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read plan %s", file)
+	}
+	var pl Plan
+	err = json.Unmarshal(data, &pl)
+	if err != nil {
+		return nil, fmt.Errorf("invalid plan %s: %s", file, err)
+	}
+	return &pl, nil
+}
+
+// group the entries of the plan per repository and images
+func (pl *Plan) images() map[string][]Image {
+	result := map[string][]Image{}
+	for _, entry := range pl.Entries {
+		tag := Tag{Name: entry.Tag, Created: entry.Created, Digest: entry.Digest}
+		images := result[entry.Repo]
+		found := false
+		for i := range images {
+			if len(tag.Digest) > 0 && images[i].Digest == tag.Digest {
+				images[i].Tags = append(images[i].Tags, tag.Name)
+				found = true
+				break
+			}
+		}
+		if !found {
+			images = append(images, Image{Digest: tag.Digest, Created: tag.Created, Tags: []string{tag.Name}, Reason: entry.Reason})
+		}
+		result[entry.Repo] = images
+	}
+	return result
+}
+
+//Plan writes the tags/images to delete to the plan file without deleting them
+func (p Plugin) Plan() error {
+	// the registry is set once normalized
+	p.planned = &Plan{Planned: time.Now()}
+	err := p.Exec()
+	if err != nil {
+		return err
+	}
+	err = p.planned.write(p.PlanFile)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("planned deletion of %d tags in %s\n", len(p.planned.Entries), p.PlanFile)
+	return nil
+}
+
+//Apply deletes the tags/images of the plan file that did not change since planning
+func (p Plugin) Apply() error {
+	// the provider detection already follows the timeout and the signals
+	defer p.start()()
+	err := p.Check()
+	if err != nil {
+		return err
+	}
+	pl, err := readPlan(p.PlanFile)
+	if err != nil {
+		return err
+	}
+	// plans of older versions have the registry as given
+	if registry, err := normalizeRegistry(pl.Registry); err == nil {
+		pl.Registry = registry
+	}
+	if pl.Registry != p.Registry {
+		return fmt.Errorf("plan is for registry %s not %s", pl.Registry, p.Registry)
+	}
+	p.tokens = p.newTokenManager()
+	if len(p.ReportFile) > 0 {
+		p.report = &Report{Registry: p.Registry, Started: time.Now()}
+	}
+	err = p.applyPlan(pl)
+	p.printRateLimit()
+	// the report is written even for a partial run
+	if reportErr := p.writeReport(); err == nil {
+		err = reportErr
+	}
+	return err
+}
+
+// delete the tags/images of the plan, repository per repository
+func (p Plugin) applyPlan(pl *Plan) error {
+	provider, err := p.newProvider()
+	if err != nil {
+		return err
+	}
+	planned := pl.images()
+	repos := make([]string, 0, len(planned))
+	for repo := range planned {
+		repos = append(repos, repo)
+	}
+	sort.Strings(repos)
+	for _, repo := range repos {
+		// no new repository once stopped
+		if err := p.interrupted(); err != nil {
+			return err
+		}
+		p.Repo = repo
+		err = p.applyRepo(provider, planned[repo])
+		if err != nil {
+			return err
+		}
+	}
+	return p.interrupted()
+}
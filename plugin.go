@@ -15,20 +15,15 @@
 package main
 
 import (
-	"encoding/base64"
-	"encoding/json"
+	"context"
 	"fmt"
-	"net/url"
+	"net/http"
 	"os"
 	"regexp"
-	"sort"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/cblomart/registry-cleanup/responses/registry"
-
-	"github.com/cblomart/registry-cleanup/responses/hub"
 	"github.com/cblomart/registry-cleanup/rest"
 )
 
@@ -37,6 +32,10 @@ const (
 	DefaultRegistry = "https://hub.docker.com"
 	//HubPageSize docker hub page size
 	HubPageSize = 100
+	//LatestTag is the tag that is never cleaned
+	LatestTag = "latest"
+	//GroupName is the name of the regex capture defining retention groups
+	GroupName = "group"
 )
 
 type (
@@ -44,15 +43,82 @@ type (
 	Plugin struct {
 		Username string
 		Password string
-		Repo     string
-		Registry string
+		// docker config file with the credentials when not provided
+		DockerConfig string
+		Repo         string
+		// pattern of the repositories to clean (glob or regex starting with ^)
+		RepoPattern string
+		// namespace to clean: docker hub user or organization, harbor project, github owner
+		Namespace string
+		Registry  string
+		// api of the registry (auto, hub, registry or harbor)
+		Provider string
+		// age of the tags on harbor (push or pull time)
+		AgeSource string
+		// github api used for the github container registry
+		GitHubAPI string
+		// list the untagged versions that no tagged index references (ghcr)
+		Untagged bool
+		// gitlab api and ci job token used for the gitlab container registry
+		GitLabAPI string
+		JobToken  string
+		// let the registry apply the retention rules (gitlab)
+		Bulk     bool
 		Insecure bool
-		Regex    string
-		Min      int
-		Max      time.Duration
-		Verbose  bool
-		DryRun   bool
-		Dump     bool
+		// transport configuration
+		CACert         string
+		CADir          string
+		ClientCert     string
+		ClientKey      string
+		TLSMinVersion  string
+		Proxy          string
+		NoProxy        string
+		RequestTimeout time.Duration
+		// retries of failed requests and rate limit
+		Retries            int
+		RetryBackoff       time.Duration
+		RateLimitThreshold int
+		PageSize           int
+		// concurrent requests reading manifests and deleting images
+		Concurrency       int
+		DeleteConcurrency int
+		// duration of the run (0 for none)
+		Timeout time.Duration
+		// report of the run
+		ReportFile   string
+		ReportFormat string
+		Regex        string
+		Min          int
+		Max          time.Duration
+		Policy       string
+		Semver       bool
+		// grandfather-father-son retention
+		KeepHourly  int
+		KeepDaily   int
+		KeepWeekly  int
+		KeepMonthly int
+		PlanFile    string
+		Verbose     bool
+		DryRun      bool
+		Dump        bool
+		// retention policy applied
+		policy *Policy
+		// plan collecting the deletions instead of executing them
+		planned *Plan
+		// registry tokens shared by the repositories of the run
+		tokens *rest.TokenManager
+		// identity token of the docker config, used as refresh token
+		identityToken string
+		// http client following the transport configuration
+		http *http.Client
+		// request budget announced by the registry
+		rateLimit *rest.RateLimit
+		// context of the requests, stopped on SIGINT/SIGTERM
+		ctx context.Context
+		// context of the deletions, only cancelled by the timeout or a second signal
+		deleteCtx context.Context
+		// report of the decisions taken on the tags
+		report *Report
 	}
 
 	//Tag tag data
@@ -61,23 +127,135 @@ type (
 		Created time.Time
 		Digest  string
 	}
+
+	// result of the deletion of an image
+	deletion struct {
+		image   Image
+		started bool
+		err     error
+	}
 )
 
 //Check the config values
 func (p *Plugin) Check() error {
 	// direct validation
-	if len(p.Username) == 0 {
-		return fmt.Errorf("empty username provided")
-	}
-	if len(p.Password) == 0 {
-		return fmt.Errorf("empty password provided")
-	}
 	if len(p.Registry) == 0 {
 		return fmt.Errorf("no registry provided")
 	}
-	if len(p.Repo) == 0 {
+	err := p.normalize()
+	if err != nil {
+		return err
+	}
+	if len(p.Repo) == 0 && len(p.RepoPattern) == 0 && len(p.Namespace) == 0 {
 		return fmt.Errorf("no repository provided")
 	}
+	// complex validations
+	// check transport configuration
+	p.http, err = rest.NewHTTPClient(rest.TransportConfig{
+		Insecure:   p.Insecure,
+		CAFile:     p.CACert,
+		CADir:      p.CADir,
+		ClientCert: p.ClientCert,
+		ClientKey:  p.ClientKey,
+		MinTLS:     p.TLSMinVersion,
+		Proxy:      p.Proxy,
+		NoProxy:    p.NoProxy,
+		Timeout:    p.RequestTimeout,
+	})
+	if err != nil {
+		return err
+	}
+	if p.Retries < 0 {
+		return fmt.Errorf("retries cannot be negative")
+	}
+	if p.Concurrency < 1 || p.DeleteConcurrency < 1 {
+		return fmt.Errorf("concurrency must be at least 1")
+	}
+	if len(p.ReportFile) > 0 {
+		err = checkReportFormat(p.ReportFormat)
+		if err != nil {
+			return err
+		}
+	}
+	p.rateLimit = &rest.RateLimit{}
+	// select the api of the registry
+	if len(p.Provider) == 0 {
+		p.Provider = ProviderAuto
+	}
+	err = checkProvider(p.Provider)
+	if err != nil {
+		return err
+	}
+	if p.Provider == ProviderAuto {
+		p.Provider = p.detectProvider()
+	}
+	// a gitlab job token replaces the credentials on gitlab, it is set in every gitlab ci job
+	jobToken := p.Provider == ProviderGitLab && len(p.Password) == 0 && len(p.JobToken) > 0
+	// without password use the credentials of the docker config (the username defaults to the repository owner)
+	if len(p.Password) == 0 && !jobToken {
+		err = p.dockerCredentials()
+		if err != nil {
+			return err
+		}
+	}
+	if len(p.identityToken) > 0 && p.Provider != ProviderRegistry {
+		return fmt.Errorf("identity token credentials are only supported on the registry provider")
+	}
+	// registries without challenge are accessed anonymously, the other apis need the credentials
+	if p.Provider != ProviderRegistry && !jobToken {
+		if len(p.Username) == 0 {
+			return fmt.Errorf("empty username provided")
+		}
+		if len(p.Password) == 0 {
+			return fmt.Errorf("empty password provided")
+		}
+	}
+	// official images are in the library namespace of docker hub
+	if p.Provider == ProviderHub && len(p.Repo) > 0 && !strings.Contains(p.Repo, "/") {
+		p.Repo = fmt.Sprintf("%s/%s", HubLibrary, p.Repo)
+	}
+	// check namespace and repository pattern
+	switch p.Provider {
+	case ProviderHub, ProviderGHCR, ProviderGitLab:
+		if len(p.RepoPattern) > 0 && len(p.Namespace) == 0 {
+			return fmt.Errorf("repository pattern on %s requires a namespace", p.Provider)
+		}
+	case ProviderRegistry:
+		if len(p.Namespace) > 0 {
+			return fmt.Errorf("namespace is not supported on %s", p.Provider)
+		}
+	}
+	if len(p.RepoPattern) > 0 {
+		err = p.checkRepoPattern()
+		if err != nil {
+			return err
+		}
+	}
+	// check the age of the tags
+	if len(p.AgeSource) == 0 {
+		p.AgeSource = AgePush
+	}
+	err = checkAgeSource(p.AgeSource)
+	if err != nil {
+		return err
+	}
+	if p.AgeSource == AgePull && p.Provider != ProviderHarbor {
+		return fmt.Errorf("pull age source is only supported on harbor")
+	}
+	if p.Untagged && p.Provider != ProviderGHCR {
+		return fmt.Errorf("untagged versions are only supported on ghcr")
+	}
+	if p.Bulk {
+		err = p.checkBulk()
+		if err != nil {
+			return err
+		}
+	}
+	// a policy file replaces regex, min and max
+	if len(p.Policy) > 0 {
+		p.policy, err = LoadPolicy(p.Policy)
+		return err
+	}
 	if len(p.Regex) == 0 {
 		return fmt.Errorf("no regex match provided")
 	}
@@ -87,12 +265,6 @@ func (p *Plugin) Check() error {
 	if p.Max.Seconds() == 0 {
 		return fmt.Errorf("no maximum age provided")
 	}
-	// complex validations
-	// check registry
-	_, err := url.Parse(p.Registry)
-	if err != nil {
-		return fmt.Errorf("registry is not in url format (%s)", p.Registry)
-	}
 	// check Regex
 	_, err = regexp.Compile(p.Regex)
 	if err != nil {
@@ -102,336 +274,196 @@ func (p *Plugin) Check() error {
 	if p.Regex == ".*" || p.Regex == "^.*$" {
 		return fmt.Errorf("regex would match everything (%s)", p.Regex)
 	}
+	p.policy = p.flagPolicy()
 	return nil
 }
 
 //Exec executes the registry-cleanup plugin
 func (p Plugin) Exec() error {
+	// the provider detection already follows the timeout and the signals
+	defer p.start()()
 	// check paramaters
 	err := p.Check()
 	if err != nil {
 		return err
 	}
-	// if default registry use docker hub api
-	if p.Registry == DefaultRegistry {
-		return p.ExecHub()
+	if p.planned != nil {
+		p.planned.Registry = p.Registry
 	}
-	// else use registry api
-	return p.ExecRegistry()
+	p.tokens = p.newTokenManager()
+	if len(p.ReportFile) > 0 {
+		p.report = &Report{Registry: p.Registry, Started: time.Now()}
+	}
+	provider, err := p.newProvider()
+	if err == nil {
+		err = p.execute(provider)
+	}
+	if err == nil {
+		err = p.interrupted()
+	}
+	p.printRateLimit()
+	// the report is written even for a partial run
+	if reportErr := p.writeReport(); err == nil {
+		err = reportErr
+	}
+	return err
 }
 
-//ExecHub executes the registry-cleanup plugin on the docker hub
-func (p Plugin) ExecHub() error {
-	// get the base url
-	baseurl := fmt.Sprintf("%s/v2/", p.Registry)
-	// initialize rest client
-	r := rest.NewClient(p.Dump, p.Insecure)
-	// get a token
-	var token hub.Token
-	err := r.Post(fmt.Sprintf("%susers/login/", baseurl), map[string]string{"username": p.Username, "password": p.Password}, &token)
-	if err != nil {
-		if p.Verbose {
-			fmt.Println(err)
-		}
-		return fmt.Errorf("could not get token")
-	}
-	if p.Verbose {
-		fmt.Printf("authenticated with %s\n", p.Username)
-	}
-	r.Headers["Authorization"] = fmt.Sprintf("Bearer %s", token.Token)
-	// get the tag list
-	var tags []hub.Tag
-	re := regexp.MustCompile(p.Regex)
-	url := fmt.Sprintf("%srepositories/%s/tags/?page_size=%d&page=%d", baseurl, p.Repo, HubPageSize, 1)
-	var tagpage hub.Tags
-	// loop trought the result pages
-	for len(url) > 0 {
-		tagpage = hub.Tags{}
-		err = r.Get(url, nil, &tagpage)
-		if err != nil {
-			if p.Verbose {
-				fmt.Println(err)
-			}
-			return fmt.Errorf("cannot get tag page")
+// create a rest client following the transport, retry and rate limit configuration
+func (p Plugin) newClient() *rest.Client {
+	r := rest.NewClient(p.Dump, p.http)
+	r.Retry = rest.RetryPolicy{MaxAttempts: p.Retries + 1, Backoff: p.RetryBackoff}
+	r.RateLimit = p.rateLimit
+	return r
+}
+
+// create the token manager of the run, with the identity token of the docker config when there is one
+func (p Plugin) newTokenManager() *rest.TokenManager {
+	tokens := rest.NewTokenManager(p.newClient(), p.Username, p.Password)
+	if len(p.identityToken) > 0 {
+		tokens.UseIdentityToken(p.identityToken)
+	}
+	return tokens
+}
+
+// delete images with a bounded number of workers and count the results in the summary
+// images already deleted count as deleted
+func (p Plugin) deleteImages(images []Image, del func(Image) error, summary *Summary) {
+	if p.DryRun {
+		for _, image := range images {
+			fmt.Printf("dryrun [%s] %s:%s (%s)\n", image.Created.Format(time.RFC822), p.Repo, image.Name(), image.Reason)
+			summary.DryRun++
 		}
-		url = tagpage.Next
-		for _, tag := range tagpage.Results {
-			if !re.MatchString(tag.Name) || tag.Name == "latest" {
-				continue
+		p.report.add(p.Repo, images, DecisionDryRun)
+		return
+	}
+	// collect the results of the workers
+	results := make(chan deletion)
+	collected := make(chan struct{})
+	go func() {
+		defer close(collected)
+		disabled := false
+		for result := range results {
+			image := result.image
+			decision := DecisionDeleted
+			switch {
+			case !result.started:
+				fmt.Printf("not deleted [%s] %s:%s (%s)\n", image.Created.Format(time.RFC822), p.Repo, image.Name(), p.interrupted())
+				summary.Skipped++
+				decision = DecisionNotDeleted
+				image.Reason = p.interrupted().Error()
+			case result.err == nil:
+				fmt.Printf("deleted [%s] %s:%s\n", image.Created.Format(time.RFC822), p.Repo, image.Name())
+				summary.Deleted++
+			case rest.IsStatus(result.err, http.StatusNotFound):
+				fmt.Printf("gone [%s] %s:%s (already deleted)\n", image.Created.Format(time.RFC822), p.Repo, image.Name())
+				summary.Deleted++
+				image.Reason = "already deleted"
+			case deleteDisabled(result.err):
+				if !disabled {
+					disabled = true
+					fmt.Fprintf(os.Stderr, "deletion is disabled on %s (enable it with REGISTRY_STORAGE_DELETE_ENABLED=true)\n", p.Registry)
+				}
+				fmt.Fprintf(os.Stderr, "error [%s] %s:%s (delete disabled)\n", image.Created.Format(time.RFC822), p.Repo, image.Name())
+				summary.Errors++
+				decision = DecisionError
+				image.Reason = "delete disabled"
+			default:
+				fmt.Fprintf(os.Stderr, "error [%s] %s:%s (%s)\n", image.Created.Format(time.RFC822), p.Repo, image.Name(), p.describe(result.err))
+				summary.Errors++
+				decision = DecisionError
+				image.Reason = p.describe(result.err)
 			}
-			tags = append(tags, tag)
+			p.report.add(p.Repo, []Image{image}, decision)
 		}
-		if p.Verbose {
-			fmt.Printf("found %d tags/images\n", len(tags))
+	}()
+	notStarted := parallel(p.ctx, p.DeleteConcurrency, len(images), func(i int) {
+		// no new deletion once stopped
+		if !p.waitRateLimit() {
+			results <- deletion{image: images[i]}
+			return
 		}
-	}
-	// order tags per date (newer to older)
-	sort.SliceStable(tags, func(i, j int) bool {
-		return tags[i].LastUpdated.After(tags[j].LastUpdated)
+		results <- deletion{image: images[i], started: true, err: del(images[i])}
 	})
-	// parse the tags in reverse order to decice which to delete
-	treshold := time.Now().Add(-p.Max)
-	var wg sync.WaitGroup
-	deleted := 0
-	errors := 0
-	for i := len(tags) - 1; i >= 0; i-- {
-		// stop if reached the minimum limit
-		if i <= p.Min-1 {
-			break
-		}
-		// delete if older than treshold
-		if tags[i].LastUpdated.Before(treshold) {
-			wg.Add(1)
-			// update the deleting flag
-			// send the delete request async
-			go func(tag hub.Tag) {
-				defer wg.Done()
-				if p.DryRun {
-					fmt.Printf("dryrun [%s] %s:%s\n", tag.LastUpdated.Format(time.RFC822), p.Repo, tag.Name)
-					errors++
-					return
-				}
-				err := r.Delete(fmt.Sprintf("%srepositories/%s/tags/%s/", baseurl, p.Repo, tag.Name), nil, nil)
-				if err != nil {
-					if p.Verbose {
-						fmt.Println(err)
-					}
-					fmt.Fprintf(os.Stderr, "error [%s] %s:%s\n", tag.LastUpdated.Format(time.RFC822), p.Repo, tag.Name)
-					errors++
-					return
-				}
-				deleted++
-				fmt.Printf("deleted [%s] %s:%s\n", tag.LastUpdated.Format(time.RFC822), p.Repo, tag.Name)
-			}(tags[i])
-		}
+	for _, i := range notStarted {
+		results <- deletion{image: images[i]}
 	}
-	// wait for the results
-	wg.Wait()
-	if errors > 0 {
-		fmt.Printf("issue deleting %d tags/images\n", errors)
-	}
-	fmt.Printf("successfully deleted %d tags/images\n", deleted)
-	return nil
+	close(results)
+	<-collected
 }
 
-//ExecRegistry executes the registry-cleanup plugin on a private registry
-func (p Plugin) ExecRegistry() error {
-	// set the base url
-	baseurl := fmt.Sprintf("%s/v2/", p.Registry)
-	// initialize rest client
-	r := rest.NewClient(p.Dump, p.Insecure)
-	// check v2
-	var headers map[string][]string
-	err := r.Head(baseurl, nil, &headers)
-	if err != nil {
-		return fmt.Errorf("%s does not support registry v2", p.Registry)
-	}
-	// registry auth realm
-	realm := ""
-	// registry auth service
-	service := ""
-	if authheader, ok := headers[registry.AuthHeader]; ok {
-		if len(authheader) != 1 {
-			return fmt.Errorf("more than one authentication header sent")
+// check if a deletion failed because deletes are disabled on the registry
+func deleteDisabled(err error) bool {
+	e, ok := rest.AsError(err)
+	return ok && (e.StatusCode == http.StatusMethodNotAllowed || e.HasCode(rest.CodeUnsupported))
+}
+
+// pause while the remaining request budget is below the threshold
+// returns false when the run stopped meanwhile
+func (p Plugin) waitRateLimit() bool {
+	for p.RateLimitThreshold > 0 {
+		low, reset := p.rateLimit.Low(p.RateLimitThreshold)
+		if !low {
+			break
 		}
-		realm, service, _, err = decodeauthheader(authheader[0])
-		if err != nil {
-			return err
+		// without reset time the budget cannot be awaited
+		if reset.IsZero() {
+			if p.Verbose {
+				fmt.Println("rate limit budget is low but its reset is unknown")
+			}
+			break
 		}
-	}
-	// authenticate for registry
-	userpass := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s", p.Username, p.Password)))
-	r.Headers["Authorization"] = fmt.Sprintf("Basic %s", userpass)
-	var token registry.TokenResp
-	err = r.Get(fmt.Sprintf("%s?service=%s&scope=repository:%s:%s", realm, service, p.Repo, registry.Scope), nil, &token)
-	if err != nil {
-		if p.Verbose {
-			fmt.Println(err)
+		fmt.Printf("rate limit budget below %d: pausing deletions until %s\n", p.RateLimitThreshold, reset.Format(time.RFC822))
+		select {
+		case <-p.ctx.Done():
+			return false
+		case <-time.After(time.Until(reset)):
 		}
-		return fmt.Errorf("could not get token")
 	}
-	// set authentication
-	if p.Verbose {
-		fmt.Printf("authenticated with %s\n", p.Username)
-	}
-	r.Headers["Authorization"] = fmt.Sprintf("Bearer %s", token.Token)
-	// get the tags list
-	var tags registry.TagsListResp
-	err = r.Get(fmt.Sprintf("%s%s/tags/list", baseurl, p.Repo), nil, &tags)
-	if err != nil {
-		if p.Verbose {
-			fmt.Println(err)
-		}
-		return fmt.Errorf("could not get tag list")
-	}
-	// filter tags list
-	var scopedTags []string
-	re := regexp.MustCompile(p.Regex)
-	for _, tag := range tags.Tags {
-		if !re.MatchString(tag) || tag == "latest" {
-			continue
-		}
-		scopedTags = append(scopedTags, tag)
+	return p.ctx.Err() == nil
+}
 
+// print the remaining request budget in verbose mode
+func (p Plugin) printRateLimit() {
+	remaining, limit, reset, ok := p.rateLimit.Remaining()
+	if !p.Verbose || !ok {
+		return
 	}
-	if p.Verbose {
-		fmt.Printf("found %d tags/images\n", len(scopedTags))
-	}
-	// set mime type for manifests
-	r.Headers["Accept"] = registry.ManifestMimeV2
-	// get informations on scoped tags
-	var tagInfos []Tag
-	var wg sync.WaitGroup
-	wg.Add(len(scopedTags))
-	for _, tag := range scopedTags {
-		go func(tag string) {
-			// defer completion
-			defer wg.Done()
-			// check version of the manifest
-			var headers map[string][]string
-			err := r.Head(fmt.Sprintf("%s%s/manifests/%s", baseurl, p.Repo, tag), nil, &headers)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "could not head manifest: %s\n", err)
-				return
-			}
-			// get the digest from headers
-			digest := ""
-			if digests, ok := headers[registry.DigestHeader]; ok {
-				digest = digests[0]
-			}
-			if len(digest) == 0 {
-				fmt.Fprintf(os.Stderr, "no digest for manifest: %s\n", tag)
-				return
-			}
-			// check manifest in function of version
-			if mimetype, ok := headers["Content-Type"]; ok {
-				switch mimetype[0] {
-				case registry.ManifestMimeV2:
-					var manifest registry.ManifestRespV2
-					err = r.Get(fmt.Sprintf("%s%s/manifests/%s", baseurl, p.Repo, tag), nil, &manifest)
-					if err != nil {
-						fmt.Fprintf(os.Stderr, "could not get manifest: %s\n", err)
-						return
-					}
-					var image registry.Image
-					err = r.Get(fmt.Sprintf("%s%s/blobs/%s", baseurl, p.Repo, manifest.Config.Digest), nil, &image)
-					if err != nil {
-						fmt.Fprintf(os.Stderr, "could not get config blob: %s\n", err)
-						return
-					}
-					tagInfos = append(tagInfos, Tag{Name: tag, Created: image.Created, Digest: digest})
-				case registry.ManifestMimeV1:
-					// get the manifest
-					var manifest registry.ManifestRespV1
-					err = r.Get(fmt.Sprintf("%s%s/manifests/%s", baseurl, p.Repo, tag), nil, &manifest)
-					if err != nil {
-						fmt.Fprintf(os.Stderr, "could not get manifest: %s\n", err)
-						return
-					}
-					// get all images informations and check for the latest
-					images := make([]registry.Image, len(manifest.History))
-					latest := -1
-					for i, h := range manifest.History {
-						err = json.Unmarshal([]byte(h.V1Compatibility), &images[i])
-						if err != nil {
-							fmt.Fprintf(os.Stderr, "could not decode image from history: %s\n", err)
-							continue
-						}
-						if latest == -1 {
-							latest = i
-							continue
-						}
-						if images[i].Created.After(images[latest].Created) {
-							latest = i
-						}
-					}
-					tagInfos = append(tagInfos, Tag{Name: tag, Created: images[latest].Created, Digest: digest})
-				default:
-					fmt.Printf("manifest type not handled for %s: %s\n", tag, mimetype[0])
-				}
-			}
-		}(tag)
+	budget := fmt.Sprintf("%d", remaining)
+	if limit > 0 {
+		budget = fmt.Sprintf("%d/%d", remaining, limit)
 	}
-	wg.Wait()
-	// indicate the details found
-	if p.Verbose {
-		fmt.Printf("found details on %d tags/images\n", len(tagInfos))
+	if reset.IsZero() {
+		fmt.Printf("rate limit budget: %s requests remaining\n", budget)
+		return
 	}
-	// order tags infos per date (newer to older)
-	sort.SliceStable(tagInfos, func(i, j int) bool {
-		return tagInfos[i].Created.After(tagInfos[j].Created)
-	})
-	// parse the tags in reverse order to decice which to delete
-	treshold := time.Now().Add(-p.Max)
-	errors := 0
-	deleted := 0
-	for i := len(tagInfos) - 1; i >= 0; i-- {
-		// stop if reached the minimum limit
-		if i <= p.Min-1 {
-			break
-		}
-		// delete if older than treshold
-		if tagInfos[i].Created.Before(treshold) {
-			wg.Add(1)
-			// send the delete request async
-			go func(tag Tag) {
-				defer wg.Done()
-				if p.DryRun {
-					fmt.Printf("dryrun [%s] %s:%s\n", tag.Created.Format(time.RFC822), p.Repo, tag.Name)
-					errors++
-					return
-				}
-				err := r.Delete(fmt.Sprintf("%s%s/manifests/%s", baseurl, p.Repo, tag.Digest), nil, nil)
-				if err != nil {
-					if p.Verbose {
-						fmt.Println(err)
-					}
-					fmt.Fprintf(os.Stderr, "error [%s] %s:%s\n", tag.Created.Format(time.RFC822), p.Repo, tag.Name)
-					errors++
-					return
-				}
-				deleted++
-				fmt.Printf("deleted [%s] %s:%s\n", tag.Created.Format(time.RFC822), p.Repo, tag.Name)
-			}(tagInfos[i])
+	fmt.Printf("rate limit budget: %s requests remaining until %s\n", budget, reset.Format(time.RFC822))
+}
+
+// explain why images are kept: always for images saved by a reference, for all in dry run
+func (p Plugin) explain(kept []Image) {
+	p.report.add(p.Repo, kept, DecisionKept)
+	for _, image := range kept {
+		if p.DryRun || p.Verbose || len(image.SavedBy) > 0 {
+			fmt.Printf("kept [%s] %s:%s (%s)\n", image.Created.Format(time.RFC822), p.Repo, image.Name(), image.Reason)
 		}
 	}
-	// if deleting wait for the results
-	wg.Wait()
-	if errors > 0 {
-		fmt.Printf("issue deleting %d tags/images\n", errors)
-	}
-	fmt.Printf("successfully deleted %d tags/images\n", deleted)
-	return nil
 }
 
-// decode registry auth header
-func decodeauthheader(header string) (string, string, string, error) {
-	// registry auth realm
-	realm := ""
-	// registry auth service
-	service := ""
-	// registry required scope to delete tags
-	scope := registry.Scope
-	matched, err := regexp.MatchString(registry.ValidAuthHeader, header)
-	if err != nil {
-		return realm, service, scope, fmt.Errorf("error validating auth header")
-	}
-	if !matched {
-		return realm, service, scope, fmt.Errorf("invalid auth header")
-	}
-	parts := strings.Split(header, " ")
-	rawfields := parts[len(parts)-1]
-	fields := strings.Split(rawfields, ",")
-	for _, field := range fields {
-		elements := strings.Split(field, "=")
-		switch elements[0] {
-		case "realm":
-			realm = elements[1][1 : len(elements[1])-1]
-		case "service":
-			service = elements[1][1 : len(elements[1])-1]
-		case "scope":
-			scope = elements[1][1 : len(elements[1])-1]
+// describe an error with the actions to take for authentication and permission problems
+func (p Plugin) describe(err error) string {
+	e, ok := rest.AsError(err)
+	if !ok {
+		return err.Error()
+	}
+	switch e.StatusCode {
+	case http.StatusUnauthorized:
+		return fmt.Sprintf("%s: authentication refused, check the credentials of %s for %s", e, p.Username, p.Registry)
+	case http.StatusForbidden:
+		if len(p.Repo) == 0 {
+			return fmt.Sprintf("%s: access denied, %s needs access to the catalog (%s)", e, p.Username, registry.CatalogScope)
 		}
+		return fmt.Sprintf("%s: access denied, %s needs pull, push and delete permissions on %s", e, p.Username, p.Repo)
 	}
-	return realm, service, scope, nil
+	return e.Error()
 }
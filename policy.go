@@ -0,0 +1,382 @@
+//   Copyright (c) 2019 cblomart
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+type (
+	//Policy is an ordered list of retention rules
+	Policy struct {
+		Rules []Rule
+	}
+
+	//Rule is a retention rule applied to the tags it matches
+	Rule struct {
+		Name         string
+		Repositories []string
+		Include      []string
+		Exclude      []string
+		Keep         int
+		MaxAge       Duration
+		Protect      []string
+		// semantic version ordering
+		Order            string
+		KeepPatches      int
+		KeepMajors       bool
+		PrereleaseMaxAge Duration
+		// grandfather-father-son time buckets
+		KeepHourly  int
+		KeepDaily   int
+		KeepWeekly  int
+		KeepMonthly int
+		// compiled patterns
+		include []*regexp.Regexp
+		exclude []*regexp.Regexp
+	}
+
+	//Duration is a duration accepting days (d) and weeks (w)
+	Duration time.Duration
+)
+
+// fields accepted in the policy document
+var (
+	policyFields = map[string]bool{"rules": true}
+	ruleFields   = map[string]bool{"name": true, "repositories": true, "include": true, "exclude": true, "keep": true, "max_age": true, "protect": true, "order": true, "keep_patches": true, "keep_majors": true, "prerelease_max_age": true, "keep_hourly": true, "keep_daily": true, "keep_weekly": true, "keep_monthly": true}
+)
+
+//LoadPolicy reads and validates a policy file (yaml or json)
+func LoadPolicy(file string) (*Policy, error) {
+	/* #nosec */
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read policy %s", file)
+	}
+	var policy Policy
+	err = yaml.Unmarshal(data, &policy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid policy %s: %s", file, strings.TrimPrefix(err.Error(), "yaml: "))
+	}
+	if len(policy.Rules) == 0 {
+		return nil, fmt.Errorf("invalid policy %s: no rules", file)
+	}
+	return &policy, nil
+}
+
+//UnmarshalYAML decodes the policy document
+func (p *Policy) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind != yaml.MappingNode {
+		return fmt.Errorf("line %d: policy must be a mapping", value.Line)
+	}
+	err := checkFields(value, policyFields)
+	if err != nil {
+		return err
+	}
+	var raw struct {
+		Rules []Rule `yaml:"rules"`
+	}
+	err = value.Decode(&raw)
+	if err != nil {
+		return err
+	}
+	p.Rules = raw.Rules
+	return nil
+}
+
+//UnmarshalYAML decodes and validates a rule
+func (r *Rule) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind != yaml.MappingNode {
+		return fmt.Errorf("line %d: rule must be a mapping", value.Line)
+	}
+	err := checkFields(value, ruleFields)
+	if err != nil {
+		return err
+	}
+	var raw struct {
+		Name         string   `yaml:"name"`
+		Repositories []string `yaml:"repositories"`
+		Include      []string `yaml:"include"`
+		Exclude      []string `yaml:"exclude"`
+		Keep         int      `yaml:"keep"`
+		MaxAge       Duration `yaml:"max_age"`
+		Protect      []string `yaml:"protect"`
+		// semantic version ordering
+		Order            string   `yaml:"order"`
+		KeepPatches      int      `yaml:"keep_patches"`
+		KeepMajors       bool     `yaml:"keep_majors"`
+		PrereleaseMaxAge Duration `yaml:"prerelease_max_age"`
+		// grandfather-father-son time buckets
+		KeepHourly  int `yaml:"keep_hourly"`
+		KeepDaily   int `yaml:"keep_daily"`
+		KeepWeekly  int `yaml:"keep_weekly"`
+		KeepMonthly int `yaml:"keep_monthly"`
+	}
+	err = value.Decode(&raw)
+	if err != nil {
+		return err
+	}
+	*r = Rule{
+		Name:         raw.Name,
+		Repositories: raw.Repositories,
+		Include:      raw.Include,
+		Exclude:      raw.Exclude,
+		Keep:         raw.Keep,
+		MaxAge:       raw.MaxAge,
+		Protect:      raw.Protect,
+		// semantic version ordering
+		Order:            raw.Order,
+		KeepPatches:      raw.KeepPatches,
+		KeepMajors:       raw.KeepMajors,
+		PrereleaseMaxAge: raw.PrereleaseMaxAge,
+		// grandfather-father-son time buckets
+		KeepHourly:  raw.KeepHourly,
+		KeepDaily:   raw.KeepDaily,
+		KeepWeekly:  raw.KeepWeekly,
+		KeepMonthly: raw.KeepMonthly,
+	}
+	if len(r.Name) == 0 {
+		r.Name = fmt.Sprintf("line %d", value.Line)
+	}
+	// validate the values with the line they are defined at
+	for i, pattern := range r.Repositories {
+		_, err = path.Match(pattern, "")
+		if err != nil {
+			return fmt.Errorf("line %d: invalid repository pattern (%s)", itemLine(value, "repositories", i), pattern)
+		}
+	}
+	for i, pattern := range r.Include {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("line %d: invalid include regex (%s)", itemLine(value, "include", i), pattern)
+		}
+		r.include = append(r.include, re)
+	}
+	for i, pattern := range r.Exclude {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("line %d: invalid exclude regex (%s)", itemLine(value, "exclude", i), pattern)
+		}
+		r.exclude = append(r.exclude, re)
+	}
+	if r.Keep < 0 {
+		return fmt.Errorf("line %d: keep cannot be negative", fieldLine(value, "keep"))
+	}
+	if r.MaxAge < 0 {
+		return fmt.Errorf("line %d: max_age cannot be negative", fieldLine(value, "max_age"))
+	}
+	switch r.Order {
+	case "":
+		r.Order = OrderCreated
+	case OrderCreated, OrderSemver:
+	default:
+		return fmt.Errorf("line %d: unknown order %s (%s or %s)", fieldLine(value, "order"), r.Order, OrderCreated, OrderSemver)
+	}
+	if r.Order != OrderSemver {
+		for _, field := range []string{"keep_patches", "keep_majors", "prerelease_max_age"} {
+			if fieldLine(value, field) != value.Line {
+				return fmt.Errorf("line %d: %s requires order %s", fieldLine(value, field), field, OrderSemver)
+			}
+		}
+	}
+	if r.KeepPatches < 0 {
+		return fmt.Errorf("line %d: keep_patches cannot be negative", fieldLine(value, "keep_patches"))
+	}
+	if r.PrereleaseMaxAge < 0 {
+		return fmt.Errorf("line %d: prerelease_max_age cannot be negative", fieldLine(value, "prerelease_max_age"))
+	}
+	for field, count := range map[string]int{"keep_hourly": r.KeepHourly, "keep_daily": r.KeepDaily, "keep_weekly": r.KeepWeekly, "keep_monthly": r.KeepMonthly} {
+		if count < 0 {
+			return fmt.Errorf("line %d: %s cannot be negative", fieldLine(value, field), field)
+		}
+		if count > 0 && r.Order == OrderSemver {
+			return fmt.Errorf("line %d: %s requires order %s", fieldLine(value, field), field, OrderCreated)
+		}
+	}
+	if r.Keep == 0 && r.MaxAge == 0 && r.KeepPatches == 0 && !r.KeepMajors && !r.isGFS() {
+		return fmt.Errorf("line %d: rule %s needs keep, max_age or another retention field", value.Line, r.Name)
+	}
+	return nil
+}
+
+//UnmarshalYAML decodes a duration
+func (d *Duration) UnmarshalYAML(value *yaml.Node) error {
+	duration, err := parseDuration(value.Value)
+	if err != nil {
+		return fmt.Errorf("line %d: invalid duration (%s)", value.Line, value.Value)
+	}
+	*d = Duration(duration)
+	return nil
+}
+
+// parse a duration accepting days and weeks as units
+func parseDuration(value string) (time.Duration, error) {
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if strings.HasSuffix(value, suffix) {
+			count, err := strconv.Atoi(strings.TrimSuffix(value, suffix))
+			if err != nil {
+				return 0, err
+			}
+			return time.Duration(count) * unit, nil
+		}
+	}
+	return time.ParseDuration(value)
+}
+
+// check that a mapping only contains known fields
+func checkFields(value *yaml.Node, fields map[string]bool) error {
+	for i := 0; i+1 < len(value.Content); i += 2 {
+		key := value.Content[i]
+		if !fields[key.Value] {
+			return fmt.Errorf("line %d: unknown field %s", key.Line, key.Value)
+		}
+	}
+	return nil
+}
+
+// get the line of a field in a mapping
+func fieldLine(value *yaml.Node, field string) int {
+	for i := 0; i+1 < len(value.Content); i += 2 {
+		if value.Content[i].Value == field {
+			return value.Content[i].Line
+		}
+	}
+	return value.Line
+}
+
+// get the line of an item of a list field in a mapping
+func itemLine(value *yaml.Node, field string, index int) int {
+	for i := 0; i+1 < len(value.Content); i += 2 {
+		if value.Content[i].Value == field {
+			items := value.Content[i+1]
+			if items.Kind == yaml.SequenceNode && index < len(items.Content) {
+				return items.Content[index].Line
+			}
+			return value.Content[i].Line
+		}
+	}
+	return value.Line
+}
+
+// check if the rule applies to a repository
+func (r *Rule) appliesTo(repo string) bool {
+	if len(r.Repositories) == 0 {
+		return true
+	}
+	for _, pattern := range r.Repositories {
+		if ok, _ := path.Match(pattern, repo); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// check if the rule matches a tag
+func (r *Rule) matches(tag string) bool {
+	// semantic version rules only handle versions
+	if r.Order == OrderSemver {
+		if _, ok := ParseVersion(tag); !ok {
+			return false
+		}
+	}
+	for _, re := range r.exclude {
+		if re.MatchString(tag) {
+			return false
+		}
+	}
+	if len(r.include) == 0 {
+		return true
+	}
+	for _, re := range r.include {
+		if re.MatchString(tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// get the retention group of a tag: the named group capture of the first include regex matching it
+func (r *Rule) group(tag string) string {
+	for _, re := range r.include {
+		parts := re.FindStringSubmatch(tag)
+		if parts == nil {
+			continue
+		}
+		for i, name := range re.SubexpNames() {
+			if name == GroupName {
+				return parts[i]
+			}
+		}
+		return ""
+	}
+	return ""
+}
+
+// check if the rule protects a tag
+func (r *Rule) protects(tag string) bool {
+	for _, protected := range r.Protect {
+		if protected == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// build the policy defined by the flags
+func (p Plugin) flagPolicy() *Policy {
+	return &Policy{Rules: []Rule{{
+		Name:    "default",
+		Include: []string{p.Regex},
+		Keep:    p.Min,
+		MaxAge:  Duration(p.Max),
+		Order:   p.order(),
+		// grandfather-father-son time buckets
+		KeepHourly:  p.KeepHourly,
+		KeepDaily:   p.KeepDaily,
+		KeepWeekly:  p.KeepWeekly,
+		KeepMonthly: p.KeepMonthly,
+		include:     []*regexp.Regexp{regexp.MustCompile(p.Regex)},
+	}}}
+}
+
+// get the order defined by the flags
+func (p Plugin) order() string {
+	if p.Semver {
+		return OrderSemver
+	}
+	return OrderCreated
+}
+
+// find the index of the first rule matching a tag of a repository (-1 if none)
+func (p Plugin) match(repo string, tag string) int {
+	// latest is never cleaned
+	if tag == LatestTag {
+		return -1
+	}
+	for i := range p.policy.Rules {
+		if p.policy.Rules[i].appliesTo(repo) && p.policy.Rules[i].matches(tag) {
+			return i
+		}
+	}
+	return -1
+}
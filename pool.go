@@ -0,0 +1,54 @@
+//   Copyright (c) 2019 cblomart
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// run count jobs on at most workers goroutines
+// no job is started once the context is done, returns the indexes of the jobs not started
+func parallel(ctx context.Context, workers int, count int, job func(i int)) []int {
+	if workers < 1 {
+		workers = 1
+	}
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers && w < count; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				job(i)
+			}
+		}()
+	}
+	var notStarted []int
+	for i := 0; i < count; i++ {
+		if ctx.Err() != nil {
+			notStarted = append(notStarted, i)
+			continue
+		}
+		select {
+		case indexes <- i:
+		case <-ctx.Done():
+			notStarted = append(notStarted, i)
+		}
+	}
+	close(indexes)
+	wg.Wait()
+	return notStarted
+}
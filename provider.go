@@ -0,0 +1,325 @@
+//   Copyright (c) 2019 cblomart
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	//ProviderAuto detects the provider from the registry
+	ProviderAuto = "auto"
+	//ProviderHub is the docker hub api
+	ProviderHub = "hub"
+	//ProviderRegistry is the registry v2 api (distribution)
+	ProviderRegistry = "registry"
+	//ProviderHarbor is the harbor v2.0 api
+	ProviderHarbor = "harbor"
+	//ProviderGHCR is the github packages api of the github container registry
+	ProviderGHCR = "ghcr"
+	//ProviderGitLab is the gitlab container registry api
+	ProviderGitLab = "gitlab"
+)
+
+type (
+	//Provider is the api of a registry used by the retention engine
+	Provider interface {
+		//Name of the provider
+		Name() string
+		//Capabilities of the provider
+		Capabilities() Capabilities
+		//Repositories lists the repositories matching the namespace and the repository pattern
+		Repositories() ([]string, error)
+		//Tags lists the tags of a repository with their details, at least the scoped ones
+		//returns the tags referencing each digest when the provider knows them
+		Tags(repo string, scoped func(string) bool) ([]Tag, map[string][]string, error)
+		//Delete deletes an image of a repository
+		Delete(repo string, image Image) error
+	}
+
+	//Capabilities describes what a provider supports
+	Capabilities struct {
+		// images are deleted per digest with all their tags instead of tag per tag
+		DeleteDigest bool
+		// the digests of all tags are known: images still referenced are protected
+		References bool
+	}
+
+	//BulkProvider is a provider applying the retention rules on the registry side
+	BulkProvider interface {
+		//BulkDelete schedules the deletion of the tags of a repository following the regex, min and max
+		BulkDelete(repo string) error
+	}
+
+	// image as last listed: its id on the provider and its tags
+	listedImage struct {
+		ID   int64
+		Tags []string
+	}
+
+	// images of the repositories per digest, as last listed, to delete them by id or with all their tags
+	listing struct {
+		images map[string]map[string]listedImage
+		lock   sync.Mutex
+	}
+)
+
+// remember the images of a repository
+func (l *listing) set(repo string, images map[string]listedImage) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.images == nil {
+		l.images = map[string]map[string]listedImage{}
+	}
+	l.images[repo] = images
+}
+
+// get an image of a repository, false when it was not listed
+func (l *listing) get(repo string, digest string) (listedImage, bool) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	image, ok := l.images[repo][digest]
+	return image, ok
+}
+
+// get the url of the first page of a list, the page size is limited to the maximum of the api
+func firstPage(list string, sizeParameter string, size int, maximum int) string {
+	if size <= 0 || size > maximum {
+		size = maximum
+	}
+	separator := "?"
+	if strings.Contains(list, "?") {
+		separator = "&"
+	}
+	return fmt.Sprintf("%s%s%s=%d&page=1", list, separator, sizeParameter, size)
+}
+
+// check a provider name
+func checkProvider(provider string) error {
+	switch provider {
+	case ProviderAuto, ProviderHub, ProviderRegistry, ProviderHarbor, ProviderGHCR, ProviderGitLab:
+		return nil
+	}
+	return fmt.Errorf("unknown provider %s (%s, %s, %s, %s, %s or %s)", provider, ProviderAuto, ProviderHub, ProviderRegistry, ProviderHarbor, ProviderGHCR, ProviderGitLab)
+}
+
+// detect the provider of the registry: docker hub, github and gitlab from their host, harbor from its api
+func (p Plugin) detectProvider() string {
+	switch p.Registry {
+	case DefaultRegistry:
+		return ProviderHub
+	case fmt.Sprintf("https://%s", GHCRHost):
+		return ProviderGHCR
+	case fmt.Sprintf("https://%s", GitLabRegistryHost):
+		return ProviderGitLab
+	}
+	if isGitLabCIRegistry(p.Registry) {
+		return ProviderGitLab
+	}
+	if p.isHarbor() {
+		return ProviderHarbor
+	}
+	return ProviderRegistry
+}
+
+// create the provider of the registry
+func (p Plugin) newProvider() (Provider, error) {
+	var provider Provider
+	switch p.Provider {
+	case ProviderHub:
+		hub, err := newHubProvider(p)
+		if err != nil {
+			return nil, err
+		}
+		provider = hub
+	case ProviderHarbor:
+		provider = newHarborProvider(p)
+	case ProviderGHCR:
+		provider = newGHCRProvider(p)
+	case ProviderGitLab:
+		provider = newGitLabProvider(p)
+	default:
+		provider = newDistributionProvider(p)
+	}
+	if p.Verbose {
+		fmt.Printf("using the %s api of %s\n", provider.Name(), p.Registry)
+	}
+	return provider, nil
+}
+
+// clean the repository or the repositories matching the namespace and the repository pattern
+func (p Plugin) execute(provider Provider) error {
+	if len(p.Namespace) == 0 && len(p.RepoPattern) == 0 {
+		// the summary is partial on errors
+		summary, err := p.clean(provider)
+		summary.print()
+		return err
+	}
+	repos, err := provider.Repositories()
+	if err != nil {
+		return err
+	}
+	return p.cleanRepos(repos, func(p Plugin) (Summary, error) {
+		return p.clean(provider)
+	})
+}
+
+// clean a repository: select the images to delete following the retention rules and delete or plan them
+func (p Plugin) clean(provider Provider) (Summary, error) {
+	summary := Summary{Repo: p.Repo}
+	// the registry applies the retention rules itself
+	if bulk, ok := provider.(BulkProvider); ok && p.Bulk {
+		summary.Scheduled = true
+		return summary, bulk.BulkDelete(p.Repo)
+	}
+	tags, references, err := provider.Tags(p.Repo, func(tag string) bool {
+		return p.match(p.Repo, tag) >= 0
+	})
+	if err != nil {
+		return summary, err
+	}
+	// select the images to delete and protect the ones still referenced
+	deletions, kept := p.plan(p.Repo, tags, references)
+	p.explain(kept)
+	summary.Kept = len(kept)
+	if p.report != nil {
+		// tags out of scope are only known by their digest
+		all := append([]Tag{}, tags...)
+		detailed := map[string]bool{}
+		for _, tag := range tags {
+			detailed[tag.Name] = true
+		}
+		for digest, names := range references {
+			for _, name := range names {
+				if !detailed[name] {
+					all = append(all, Tag{Name: name, Digest: digest})
+				}
+			}
+		}
+		p.report.add(p.Repo, p.ignored(p.Repo, all), DecisionKept)
+	}
+	if p.planned != nil {
+		p.planned.add(p.Repo, deletions)
+		p.report.add(p.Repo, deletions, DecisionPlanned)
+		summary.Planned = len(deletions)
+		return summary, nil
+	}
+	p.deleteImages(deletions, func(image Image) error {
+		return provider.Delete(p.Repo, image)
+	}, &summary)
+	return summary, nil
+}
+
+// apply the plan of a repository: only the images that did not change since planning are deleted
+func (p Plugin) applyRepo(provider Provider, images []Image) error {
+	// only the digests of the tags are needed
+	tags, references, err := provider.Tags(p.Repo, func(string) bool { return false })
+	if err != nil {
+		return err
+	}
+	capabilities := provider.Capabilities()
+	if !capabilities.References {
+		references = nil
+	}
+	current := map[string]Tag{}
+	for _, tag := range tags {
+		current[tag.Name] = tag
+	}
+	for digest, names := range references {
+		for _, name := range names {
+			if _, ok := current[name]; !ok {
+				current[name] = Tag{Name: name, Digest: digest}
+			}
+		}
+	}
+	var unchanged []Image
+	for _, image := range images {
+		candidates := []Image{image}
+		// tags deleted one by one are checked one by one
+		if !capabilities.DeleteDigest {
+			candidates = nil
+			for _, tag := range image.Tags {
+				candidates = append(candidates, Image{Digest: image.Digest, Created: image.Created, Tags: []string{tag}, Reason: image.Reason})
+			}
+		}
+		for _, candidate := range candidates {
+			reason := p.changed(candidate, current, references)
+			if len(reason) > 0 {
+				p.skip(candidate, reason)
+				continue
+			}
+			unchanged = append(unchanged, candidate)
+		}
+	}
+	summary := Summary{Repo: p.Repo}
+	p.deleteImages(unchanged, func(image Image) error {
+		return provider.Delete(p.Repo, image)
+	}, &summary)
+	summary.print()
+	return nil
+}
+
+// check if a planned image changed, returns the reason to skip it
+// without references an image is gone when all its tags are
+func (p Plugin) changed(image Image, current map[string]Tag, references map[string][]string) string {
+	planned := map[string]bool{}
+	found := false
+	for _, name := range image.Tags {
+		planned[name] = true
+		tag, ok := current[name]
+		if !ok {
+			continue
+		}
+		found = true
+		// compare digests when known, creation times otherwise
+		if len(image.Digest) > 0 && tag.Digest != image.Digest {
+			return fmt.Sprintf("%s changed since planning", name)
+		}
+		if len(image.Digest) == 0 && !tag.Created.Equal(image.Created) {
+			return fmt.Sprintf("%s changed since planning", name)
+		}
+	}
+	if references == nil {
+		if !found {
+			return "already deleted"
+		}
+		return ""
+	}
+	if len(references[image.Digest]) == 0 {
+		return "already deleted"
+	}
+	var others []string
+	for _, name := range references[image.Digest] {
+		if !planned[name] {
+			others = append(others, name)
+		}
+	}
+	if len(others) > 0 {
+		sort.Strings(others)
+		return fmt.Sprintf("referenced by %s since planning", strings.Join(others, ","))
+	}
+	return ""
+}
+
+// report a planned image that is not deleted
+func (p Plugin) skip(image Image, reason string) {
+	image.Reason = reason
+	p.report.add(p.Repo, []Image{image}, DecisionKept)
+	fmt.Printf("skipped [%s] %s:%s (%s)\n", image.Created.Format(time.RFC822), p.Repo, image.Name(), reason)
+}
@@ -0,0 +1,153 @@
+//   Copyright (c) 2019 cblomart
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const (
+	//HubLibrary is the namespace of the official images on docker hub
+	HubLibrary = "library"
+)
+
+var (
+	// path component of a repository (distribution reference grammar)
+	pathComponent = regexp.MustCompile(`^[a-z0-9]+(?:(?:[._]|__|[-]*)[a-z0-9]+)*$`)
+	// domain of a reference: host names or ip addresses with an optional port
+	domain = regexp.MustCompile(`^(?:[a-zA-Z0-9](?:[a-zA-Z0-9-]*[a-zA-Z0-9])?(?:\.[a-zA-Z0-9](?:[a-zA-Z0-9-]*[a-zA-Z0-9])?)*|\[[0-9a-fA-F:.]+\])(?::[0-9]+)?$`)
+)
+
+// check if a host is one of the docker hub aliases
+func isHubHost(host string) bool {
+	switch strings.ToLower(host) {
+	case "docker.io", "index.docker.io", "registry-1.docker.io", "hub.docker.com", "cloud.docker.com":
+		return true
+	}
+	return false
+}
+
+// normalize a registry to its scheme and host: https without scheme, lowercase host without default port
+// docker hub aliases are normalized to the docker hub api
+func normalizeRegistry(registry string) (string, error) {
+	raw := strings.TrimSpace(registry)
+	if !strings.Contains(raw, "://") {
+		raw = fmt.Sprintf("https://%s", raw)
+	}
+	u, err := url.Parse(raw)
+	if err != nil || len(u.Hostname()) == 0 {
+		return "", fmt.Errorf("registry is not in url format (%s)", registry)
+	}
+	scheme := strings.ToLower(u.Scheme)
+	if scheme != "http" && scheme != "https" {
+		return "", fmt.Errorf("registry scheme must be http or https (%s)", registry)
+	}
+	if len(strings.Trim(u.Path, "/")) > 0 || len(u.RawQuery) > 0 || len(u.Fragment) > 0 || u.User != nil {
+		return "", fmt.Errorf("registry must only be a scheme and a host (%s)", registry)
+	}
+	host := strings.ToLower(u.Hostname())
+	if isHubHost(host) {
+		return DefaultRegistry, nil
+	}
+	port := u.Port()
+	if (scheme == "https" && port == "443") || (scheme == "http" && port == "80") {
+		port = ""
+	}
+	if len(port) > 0 {
+		host = net.JoinHostPort(host, port)
+	} else if strings.Contains(host, ":") {
+		// ipv6 address
+		host = fmt.Sprintf("[%s]", host)
+	}
+	return fmt.Sprintf("%s://%s", scheme, host), nil
+}
+
+// split an image reference in its registry (empty when not in the reference) and its repository
+// the first component is the registry when it contains a dot or a port or is localhost (distribution reference grammar)
+func splitReference(reference string) (string, string, error) {
+	ref := reference
+	scheme := ""
+	if i := strings.Index(ref, "://"); i >= 0 {
+		scheme = ref[:i+3]
+		ref = ref[i+3:]
+	}
+	ref = strings.TrimSuffix(ref, "/")
+	if strings.Contains(ref, "@") {
+		return "", "", fmt.Errorf("repository cannot have a digest (%s)", reference)
+	}
+	registry := ""
+	i := strings.Index(ref, "/")
+	if i >= 0 && (strings.ContainsAny(ref[:i], ".:") || ref[:i] == "localhost" || len(scheme) > 0) {
+		registry = ref[:i]
+		ref = ref[i+1:]
+		if !domain.MatchString(registry) {
+			return "", "", fmt.Errorf("invalid registry in repository (%s)", reference)
+		}
+	} else if len(scheme) > 0 {
+		return "", "", fmt.Errorf("repository has a scheme without registry (%s)", reference)
+	}
+	if strings.Contains(ref, ":") {
+		return "", "", fmt.Errorf("repository cannot have a tag (%s)", reference)
+	}
+	for _, component := range strings.Split(ref, "/") {
+		if !pathComponent.MatchString(component) {
+			return "", "", fmt.Errorf("invalid repository (%s)", reference)
+		}
+	}
+	if len(registry) > 0 {
+		registry = scheme + registry
+	}
+	return registry, ref, nil
+}
+
+// normalize the registry and the repository, a registry in the repository selects it
+// the repository is only parsed when it is cleaned: it defaults to the drone repository
+func (p *Plugin) normalize() error {
+	if len(p.Repo) > 0 && len(p.Namespace) == 0 && len(p.RepoPattern) == 0 {
+		registry, repo, err := splitReference(p.Repo)
+		if err != nil {
+			return err
+		}
+		if len(registry) > 0 {
+			registry, err = normalizeRegistry(registry)
+			if err != nil {
+				return err
+			}
+			// the default registry is replaced, another one must be on the same host
+			current, err := normalizeRegistry(p.Registry)
+			if err == nil && current != DefaultRegistry {
+				if registryHost(current) != registryHost(registry) {
+					return fmt.Errorf("repository %s is not on registry %s", p.Repo, p.Registry)
+				}
+				// the scheme of the registry is kept unless the repository has one
+				if !strings.Contains(p.Repo, "://") {
+					registry = current
+				}
+			}
+			p.Registry = registry
+		}
+		p.Repo = repo
+	}
+	registry, err := normalizeRegistry(p.Registry)
+	if err != nil {
+		return err
+	}
+	p.Registry = registry
+	return nil
+}
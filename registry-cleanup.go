@@ -30,6 +30,18 @@ func main() {
 	app.Name = "registry-cleanup"
 	app.Usage = "Clean a registry repository from lingering tags/images"
 	app.Action = run
+	app.Commands = []cli.Command{
+		{
+			Name:   "plan",
+			Usage:  "Write the tags/images to delete to the plan file",
+			Action: plan,
+		},
+		{
+			Name:   "apply",
+			Usage:  "Delete the tags/images of the plan file that did not change since planning",
+			Action: apply,
+		},
+	}
 	app.Version = fmt.Sprintf("%s - %s (%s)", gitTag, gitShortCommit, gitStatus)
 	app.Authors = []cli.Author{
 		cli.Author{Name: "Cédric Blomart", Email: "cblomart@gmail.com"},
@@ -46,22 +58,157 @@ func main() {
 			Usage:  "Docker password",
 			EnvVar: "PLUGIN_PASSWORD,DOCKER_PASSWORD",
 		},
+		cli.StringFlag{
+			Name:   "docker-config",
+			Usage:  "Docker config file with the credentials used without password (default: ~/.docker/config.json)",
+			EnvVar: "PLUGIN_DOCKER_CONFIG",
+		},
 		cli.StringFlag{
 			Name:   "repo, r",
-			Usage:  "Repository to target",
+			Usage:  "Repository to target, an image reference with a registry (ghcr.io/org/app) selects the registry",
 			EnvVar: "PLUGIN_REPO,DRONE_REPO",
 		},
+		cli.StringFlag{
+			Name:   "repo-pattern",
+			Usage:  "Clean all repositories matching a glob (or a regex starting with ^) instead of repo",
+			EnvVar: "PLUGIN_REPO_PATTERN",
+		},
+		cli.StringFlag{
+			Name:   "namespace",
+			Usage:  "Clean all repositories of a docker hub namespace, harbor project, github owner or gitlab group (filtered by repo-pattern)",
+			EnvVar: "PLUGIN_NAMESPACE",
+		},
 		cli.StringFlag{
 			Name:   "registry",
 			Value:  DefaultRegistry,
 			Usage:  "Registry to target",
 			EnvVar: "PLUGIN_REGISTRY",
 		},
+		cli.StringFlag{
+			Name:   "provider",
+			Value:  ProviderAuto,
+			Usage:  "Api of the registry: hub, registry, harbor, ghcr, gitlab or auto to detect it from the registry",
+			EnvVar: "PLUGIN_PROVIDER",
+		},
+		cli.StringFlag{
+			Name:   "age-source",
+			Value:  AgePush,
+			Usage:  "Age of the tags on harbor: push time or last pull time (push or pull)",
+			EnvVar: "PLUGIN_AGE_SOURCE",
+		},
+		cli.StringFlag{
+			Name:   "github-api",
+			Value:  DefaultGitHubAPI,
+			Usage:  "Github api managing the packages of the github container registry",
+			EnvVar: "PLUGIN_GITHUB_API",
+		},
+		cli.BoolFlag{
+			Name:   "untagged",
+			Usage:  "Clean the untagged versions of ghcr packages that no tagged index references",
+			EnvVar: "PLUGIN_UNTAGGED",
+		},
+		cli.StringFlag{
+			Name:   "gitlab-api",
+			Value:  DefaultGitLabAPI,
+			Usage:  "Gitlab api managing the gitlab container registry",
+			EnvVar: "PLUGIN_GITLAB_API,CI_API_V4_URL",
+		},
+		cli.StringFlag{
+			Name:   "job-token",
+			Usage:  "Gitlab ci job token used without password on gitlab",
+			EnvVar: "PLUGIN_JOB_TOKEN,CI_JOB_TOKEN",
+		},
+		cli.BoolFlag{
+			Name:   "bulk",
+			Usage:  "Let gitlab delete the tags with its bulk delete (regex, min and max only)",
+			EnvVar: "PLUGIN_BULK",
+		},
+		cli.IntFlag{
+			Name:   "page-size",
+			Value:  100,
+			Usage:  "Number of tags/repositories requested per page on custom registries (0 for the registry default)",
+			EnvVar: "PLUGIN_PAGE_SIZE",
+		},
 		cli.BoolFlag{
 			Name:   "insecure, i",
 			Usage:  "Skip TLS verification",
 			EnvVar: "PLUGIN_INSECURE",
 		},
+		cli.StringFlag{
+			Name:   "ca-cert",
+			Usage:  "Certificate authorities (pem file) trusted in addition to the system ones",
+			EnvVar: "PLUGIN_CA_CERT",
+		},
+		cli.StringFlag{
+			Name:   "ca-dir",
+			Usage:  "Directory of certificate authorities (.crt/.pem files) trusted in addition to the system ones",
+			EnvVar: "PLUGIN_CA_DIR",
+		},
+		cli.StringFlag{
+			Name:   "client-cert",
+			Usage:  "Client certificate (pem file) for mutual TLS",
+			EnvVar: "PLUGIN_CLIENT_CERT",
+		},
+		cli.StringFlag{
+			Name:   "client-key",
+			Usage:  "Client certificate key (pem file) for mutual TLS",
+			EnvVar: "PLUGIN_CLIENT_KEY",
+		},
+		cli.StringFlag{
+			Name:   "tls-min-version",
+			Usage:  "Minimum TLS version (1.0, 1.1, 1.2 or 1.3)",
+			EnvVar: "PLUGIN_TLS_MIN_VERSION",
+		},
+		cli.StringFlag{
+			Name:   "proxy",
+			Usage:  "Proxy url (http, https or socks5), the proxy environment variables are used by default",
+			EnvVar: "PLUGIN_PROXY",
+		},
+		cli.StringFlag{
+			Name:   "no-proxy",
+			Usage:  "Hosts, domains, ips and cidr ranges reached without the proxy (comma separated)",
+			EnvVar: "PLUGIN_NO_PROXY,NO_PROXY,no_proxy",
+		},
+		cli.DurationFlag{
+			Name:   "request-timeout",
+			Value:  60 * time.Second,
+			Usage:  "Timeout of a request (0 for none)",
+			EnvVar: "PLUGIN_REQUEST_TIMEOUT",
+		},
+		cli.IntFlag{
+			Name:   "retries",
+			Value:  3,
+			Usage:  "Number of retries of failed requests (network errors, 429 and 5xx)",
+			EnvVar: "PLUGIN_RETRIES",
+		},
+		cli.DurationFlag{
+			Name:   "retry-backoff",
+			Value:  time.Second,
+			Usage:  "Wait before the first retry, doubled at each retry",
+			EnvVar: "PLUGIN_RETRY_BACKOFF",
+		},
+		cli.IntFlag{
+			Name:   "rate-limit-threshold",
+			Usage:  "Pause deletions until the rate limit resets when fewer requests remain (0 to disable)",
+			EnvVar: "PLUGIN_RATE_LIMIT_THRESHOLD",
+		},
+		cli.IntFlag{
+			Name:   "concurrency",
+			Value:  10,
+			Usage:  "Number of concurrent requests reading manifests",
+			EnvVar: "PLUGIN_CONCURRENCY",
+		},
+		cli.IntFlag{
+			Name:   "delete-concurrency",
+			Value:  4,
+			Usage:  "Number of concurrent deletions",
+			EnvVar: "PLUGIN_DELETE_CONCURRENCY",
+		},
+		cli.DurationFlag{
+			Name:   "timeout",
+			Usage:  "Maximum duration of the run, requests in progress are cancelled (0 for none)",
+			EnvVar: "PLUGIN_TIMEOUT",
+		},
 		cli.StringFlag{
 			Name:   "regex",
 			Value:  "^[0-9A-Fa-f]+$",
@@ -80,6 +227,53 @@ func main() {
 			Usage:  "Maximum age of tags/images",
 			EnvVar: "PLUGIN_MAX",
 		},
+		cli.BoolFlag{
+			Name:   "semver",
+			Usage:  "Order tags/images per semantic version instead of creation date",
+			EnvVar: "PLUGIN_SEMVER",
+		},
+		cli.IntFlag{
+			Name:   "keep-hourly",
+			Usage:  "Keep the newest tag/image of each of the last hours",
+			EnvVar: "PLUGIN_KEEP_HOURLY",
+		},
+		cli.IntFlag{
+			Name:   "keep-daily",
+			Usage:  "Keep the newest tag/image of each of the last days",
+			EnvVar: "PLUGIN_KEEP_DAILY",
+		},
+		cli.IntFlag{
+			Name:   "keep-weekly",
+			Usage:  "Keep the newest tag/image of each of the last weeks",
+			EnvVar: "PLUGIN_KEEP_WEEKLY",
+		},
+		cli.IntFlag{
+			Name:   "keep-monthly",
+			Usage:  "Keep the newest tag/image of each of the last months",
+			EnvVar: "PLUGIN_KEEP_MONTHLY",
+		},
+		cli.StringFlag{
+			Name:   "policy",
+			Usage:  "Retention policy file (yaml/json), replaces regex, min and max",
+			EnvVar: "PLUGIN_POLICY",
+		},
+		cli.StringFlag{
+			Name:   "report",
+			Usage:  "Report file of the decisions taken on the tags",
+			EnvVar: "PLUGIN_REPORT",
+		},
+		cli.StringFlag{
+			Name:   "report-format",
+			Value:  ReportJSON,
+			Usage:  "Format of the report (json, junit or markdown)",
+			EnvVar: "PLUGIN_REPORT_FORMAT",
+		},
+		cli.StringFlag{
+			Name:   "plan-file",
+			Value:  "registry-cleanup.plan.json",
+			Usage:  "Plan file written by plan and read by apply",
+			EnvVar: "PLUGIN_PLAN_FILE",
+		},
 		cli.BoolFlag{
 			Name:   "verbose",
 			Usage:  "Show verbose information",
@@ -102,20 +296,70 @@ func main() {
 	}
 }
 
-func run(c *cli.Context) error {
-	plugin := Plugin{
-		Username: c.String("username"),
-		Password: c.String("password"),
-		Repo:     c.String("repo"),
-		Registry: c.String("registry"),
-		Insecure: c.Bool("insecure"),
-		Regex:    c.String("regex"),
-		Min:      c.Int("min"),
-		Max:      c.Duration("max"),
-		Verbose:  c.Bool("verbose"),
-		DryRun:   c.Bool("dryrun"),
-		Dump:     c.Bool("dump"),
+// create the plugin from the global flags
+func newPlugin(c *cli.Context) Plugin {
+	return Plugin{
+		Username:     c.GlobalString("username"),
+		Password:     c.GlobalString("password"),
+		DockerConfig: c.GlobalString("docker-config"),
+		Repo:         c.GlobalString("repo"),
+		RepoPattern:  c.GlobalString("repo-pattern"),
+		Namespace:    c.GlobalString("namespace"),
+		Registry:     c.GlobalString("registry"),
+		Provider:     c.GlobalString("provider"),
+		AgeSource:    c.GlobalString("age-source"),
+		GitHubAPI:    c.GlobalString("github-api"),
+		Untagged:     c.GlobalBool("untagged"),
+		GitLabAPI:    c.GlobalString("gitlab-api"),
+		JobToken:     c.GlobalString("job-token"),
+		Bulk:         c.GlobalBool("bulk"),
+		Insecure:     c.GlobalBool("insecure"),
+		// transport configuration
+		CACert:         c.GlobalString("ca-cert"),
+		CADir:          c.GlobalString("ca-dir"),
+		ClientCert:     c.GlobalString("client-cert"),
+		ClientKey:      c.GlobalString("client-key"),
+		TLSMinVersion:  c.GlobalString("tls-min-version"),
+		Proxy:          c.GlobalString("proxy"),
+		NoProxy:        c.GlobalString("no-proxy"),
+		RequestTimeout: c.GlobalDuration("request-timeout"),
+		// retries of failed requests and rate limit
+		Retries:            c.GlobalInt("retries"),
+		RetryBackoff:       c.GlobalDuration("retry-backoff"),
+		RateLimitThreshold: c.GlobalInt("rate-limit-threshold"),
+		Timeout:            c.GlobalDuration("timeout"),
+		// concurrent requests
+		Concurrency:       c.GlobalInt("concurrency"),
+		DeleteConcurrency: c.GlobalInt("delete-concurrency"),
+		PageSize:          c.GlobalInt("page-size"),
+		Regex:             c.GlobalString("regex"),
+		Min:               c.GlobalInt("min"),
+		Max:               c.GlobalDuration("max"),
+		Policy:            c.GlobalString("policy"),
+		Semver:            c.GlobalBool("semver"),
+		// grandfather-father-son retention
+		KeepHourly:  c.GlobalInt("keep-hourly"),
+		KeepDaily:   c.GlobalInt("keep-daily"),
+		KeepWeekly:  c.GlobalInt("keep-weekly"),
+		KeepMonthly: c.GlobalInt("keep-monthly"),
+		Verbose:     c.GlobalBool("verbose"),
+		DryRun:      c.GlobalBool("dryrun"),
+		PlanFile:    c.GlobalString("plan-file"),
+		// report of the run
+		ReportFile:   c.GlobalString("report"),
+		ReportFormat: c.GlobalString("report-format"),
+		Dump:         c.GlobalBool("dump"),
 	}
+}
+
+func run(c *cli.Context) error {
+	return newPlugin(c).Exec()
+}
+
+func plan(c *cli.Context) error {
+	return newPlugin(c).Plan()
+}
 
-	return plugin.Exec()
+func apply(c *cli.Context) error {
+	return newPlugin(c).Apply()
 }
@@ -0,0 +1,271 @@
+//   Copyright (c) 2019 cblomart
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	//ReportJSON is the json report format
+	ReportJSON = "json"
+	//ReportJUnit is the junit xml report format
+	ReportJUnit = "junit"
+	//ReportMarkdown is the markdown report format
+	ReportMarkdown = "markdown"
+
+	//DecisionKept is the decision on tags that are kept
+	DecisionKept = "kept"
+	//DecisionPlanned is the decision on tags written to the plan
+	DecisionPlanned = "planned"
+	//DecisionDryRun is the decision on tags that would be deleted
+	DecisionDryRun = "dry-run"
+	//DecisionDeleted is the decision on deleted tags
+	DecisionDeleted = "deleted"
+	//DecisionError is the decision on tags that could not be deleted
+	DecisionError = "error"
+	//DecisionNotDeleted is the decision on tags whose deletion was not started (run stopped)
+	DecisionNotDeleted = "not-deleted"
+)
+
+// decisions in report order
+var decisions = []string{DecisionKept, DecisionPlanned, DecisionDryRun, DecisionDeleted, DecisionError, DecisionNotDeleted}
+
+type (
+	//Report records the decision taken on every tag considered during a run
+	Report struct {
+		Registry string         `json:"registry"`
+		Started  time.Time      `json:"started"`
+		Finished time.Time      `json:"finished"`
+		Summary  map[string]int `json:"summary"`
+		Entries  []ReportEntry  `json:"entries"`
+		lock     sync.Mutex
+	}
+
+	//ReportEntry is the decision taken on a tag
+	ReportEntry struct {
+		Repo     string    `json:"repo"`
+		Tag      string    `json:"tag"`
+		Digest   string    `json:"digest,omitempty"`
+		Created  time.Time `json:"created"`
+		Decision string    `json:"decision"`
+		Reason   string    `json:"reason"`
+	}
+
+	// junit xml documents
+	junitSuites struct {
+		XMLName  xml.Name     `xml:"testsuites"`
+		Name     string       `xml:"name,attr"`
+		Tests    int          `xml:"tests,attr"`
+		Failures int          `xml:"failures,attr"`
+		Skipped  int          `xml:"skipped,attr"`
+		Suites   []junitSuite `xml:"testsuite"`
+	}
+	junitSuite struct {
+		Name      string      `xml:"name,attr"`
+		Tests     int         `xml:"tests,attr"`
+		Failures  int         `xml:"failures,attr"`
+		Skipped   int         `xml:"skipped,attr"`
+		Timestamp string      `xml:"timestamp,attr"`
+		Cases     []junitCase `xml:"testcase"`
+	}
+	junitCase struct {
+		Name      string        `xml:"name,attr"`
+		ClassName string        `xml:"classname,attr"`
+		Failure   *junitMessage `xml:"failure,omitempty"`
+		Skipped   *junitMessage `xml:"skipped,omitempty"`
+		Output    string        `xml:"system-out,omitempty"`
+	}
+	junitMessage struct {
+		Message string `xml:"message,attr"`
+	}
+)
+
+// check a report format
+func checkReportFormat(format string) error {
+	switch format {
+	case ReportJSON, ReportJUnit, ReportMarkdown:
+		return nil
+	}
+	return fmt.Errorf("unknown report format %s (%s, %s or %s)", format, ReportJSON, ReportJUnit, ReportMarkdown)
+}
+
+// record the decision taken on the tags of images
+func (rp *Report) add(repo string, images []Image, decision string) {
+	if rp == nil {
+		return
+	}
+	rp.lock.Lock()
+	defer rp.lock.Unlock()
+	for _, image := range images {
+		for _, tag := range image.Tags {
+			rp.Entries = append(rp.Entries, ReportEntry{Repo: repo, Tag: tag, Digest: image.Digest, Created: image.Created, Decision: decision, Reason: image.Reason})
+		}
+	}
+}
+
+// write the report to a file in the format
+func (rp *Report) write(file string, format string) error {
+	rp.lock.Lock()
+	defer rp.lock.Unlock()
+	rp.Finished = time.Now()
+	sort.SliceStable(rp.Entries, func(i, j int) bool {
+		if rp.Entries[i].Repo != rp.Entries[j].Repo {
+			return rp.Entries[i].Repo < rp.Entries[j].Repo
+		}
+		return rp.Entries[i].Tag < rp.Entries[j].Tag
+	})
+	rp.Summary = map[string]int{}
+	for _, entry := range rp.Entries {
+		rp.Summary[entry.Decision]++
+	}
+	var data []byte
+	var err error
+	switch format {
+	case ReportJUnit:
+		data, err = rp.junit()
+	case ReportMarkdown:
+		data = rp.markdown()
+	default:
+		data, err = json.MarshalIndent(rp, "", "  ")
+	}
+	if err != nil {
+		return fmt.Errorf("cannot serialise report")
+	}
+	err = ioutil.WriteFile(file, data, 0600)
+	if err != nil {
+		return fmt.Errorf("cannot write report %s", file)
+	}
+	return nil
+}
+
+// junit report: a test suite per repository and a test case per tag selected for deletion
+func (rp *Report) junit() ([]byte, error) {
+	suites := junitSuites{Name: fmt.Sprintf("registry-cleanup %s", rp.Registry)}
+	index := map[string]int{}
+	for _, entry := range rp.Entries {
+		if entry.Decision == DecisionKept {
+			continue
+		}
+		i, ok := index[entry.Repo]
+		if !ok {
+			i = len(suites.Suites)
+			index[entry.Repo] = i
+			suites.Suites = append(suites.Suites, junitSuite{Name: entry.Repo, Timestamp: rp.Started.Format(time.RFC3339)})
+		}
+		suite := &suites.Suites[i]
+		c := junitCase{Name: fmt.Sprintf("%s:%s", entry.Repo, entry.Tag), ClassName: entry.Repo, Output: entry.Reason}
+		switch entry.Decision {
+		case DecisionError:
+			c.Failure = &junitMessage{Message: entry.Reason}
+			suite.Failures++
+		case DecisionPlanned, DecisionDryRun, DecisionNotDeleted:
+			c.Skipped = &junitMessage{Message: entry.Decision}
+			suite.Skipped++
+		}
+		suite.Tests++
+		suite.Cases = append(suite.Cases, c)
+	}
+	for _, suite := range suites.Suites {
+		suites.Tests += suite.Tests
+		suites.Failures += suite.Failures
+		suites.Skipped += suite.Skipped
+	}
+	data, err := xml.MarshalIndent(suites, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), data...), nil
+}
+
+// markdown report: the counts per decision and a table of the tags
+func (rp *Report) markdown() []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "# registry-cleanup report\n\n")
+	fmt.Fprintf(&b, "Registry %s, %s to %s.\n\n", rp.Registry, rp.Started.Format(time.RFC3339), rp.Finished.Format(time.RFC3339))
+	fmt.Fprintf(&b, "| Decision | Tags |\n| --- | ---: |\n")
+	for _, decision := range decisions {
+		if rp.Summary[decision] > 0 {
+			fmt.Fprintf(&b, "| %s | %d |\n", decision, rp.Summary[decision])
+		}
+	}
+	fmt.Fprintf(&b, "\n| Repository | Tag | Created | Digest | Decision | Reason |\n| --- | --- | --- | --- | --- | --- |\n")
+	for _, entry := range rp.Entries {
+		created := ""
+		if !entry.Created.IsZero() {
+			created = entry.Created.Format(time.RFC3339)
+		}
+		fmt.Fprintf(&b, "| %s | %s | %s | %s | %s | %s |\n", entry.Repo, entry.Tag, created, shortDigest(entry.Digest), entry.Decision, markdownEscape(entry.Reason))
+	}
+	return b.Bytes()
+}
+
+// shorten a digest for display
+func shortDigest(digest string) string {
+	parts := strings.SplitN(digest, ":", 2)
+	if len(parts) == 2 && len(parts[1]) > 12 {
+		return fmt.Sprintf("%s:%s", parts[0], parts[1][:12])
+	}
+	return digest
+}
+
+// escape the characters breaking a markdown table cell
+func markdownEscape(s string) string {
+	return strings.NewReplacer("|", "\\|", "\n", " ").Replace(s)
+}
+
+// get the tags left out of the retention rules with the reason (for the report)
+func (p Plugin) ignored(repo string, tags []Tag) []Image {
+	var result []Image
+	for _, tag := range tags {
+		image := Image{Digest: tag.Digest, Created: tag.Created, Tags: []string{tag.Name}}
+		i := p.match(repo, tag.Name)
+		switch {
+		case tag.Name == LatestTag:
+			image.Reason = "latest is never cleaned"
+		case i < 0:
+			image.Reason = "not matched"
+		case p.policy.Rules[i].protects(tag.Name):
+			image.Reason = fmt.Sprintf("protected by rule %s", p.policy.Rules[i].Name)
+		default:
+			continue
+		}
+		result = append(result, image)
+	}
+	return result
+}
+
+// write the report of the run if requested
+func (p Plugin) writeReport() error {
+	if p.report == nil {
+		return nil
+	}
+	err := p.report.write(p.ReportFile, p.ReportFormat)
+	if err != nil {
+		return err
+	}
+	if p.Verbose {
+		fmt.Printf("report written to %s\n", p.ReportFile)
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+//   Copyright (c) 2019 cblomart
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+//Summary counts the results of the cleanup of a repository
+type Summary struct {
+	Repo    string
+	Kept    int
+	Planned int
+	DryRun  int
+	Deleted int
+	Errors  int
+	// deletions not started because the run stopped
+	Skipped int
+	// deletions left to the registry (bulk delete)
+	Scheduled bool
+}
+
+// print the summary of a repository
+func (s Summary) print() {
+	if s.Scheduled {
+		fmt.Println("deletions scheduled on the registry")
+		return
+	}
+	if s.Planned > 0 {
+		fmt.Printf("planned %d tags/images\n", s.Planned)
+	}
+	if s.DryRun > 0 {
+		fmt.Printf("would delete %d tags/images\n", s.DryRun)
+	}
+	if s.Errors > 0 {
+		fmt.Printf("issue deleting %d tags/images\n", s.Errors)
+	}
+	if s.Skipped > 0 {
+		fmt.Printf("not deleted %d tags/images (stopped)\n", s.Skipped)
+	}
+	fmt.Printf("successfully deleted %d tags/images\n", s.Deleted)
+}
+
+// add the counts of another summary
+func (s *Summary) add(o Summary) {
+	s.Kept += o.Kept
+	s.Planned += o.Planned
+	s.DryRun += o.DryRun
+	s.Deleted += o.Deleted
+	s.Errors += o.Errors
+	s.Skipped += o.Skipped
+}
+
+// check the repository pattern: a regex when starting with ^, a glob otherwise
+func (p Plugin) checkRepoPattern() error {
+	if strings.HasPrefix(p.RepoPattern, "^") {
+		_, err := regexp.Compile(p.RepoPattern)
+		if err != nil {
+			return fmt.Errorf("invalid repository regex provided (%s)", p.RepoPattern)
+		}
+		return nil
+	}
+	_, err := path.Match(p.RepoPattern, "")
+	if err != nil {
+		return fmt.Errorf("invalid repository pattern provided (%s)", p.RepoPattern)
+	}
+	return nil
+}
+
+// check if a repository matches the repository pattern
+func (p Plugin) matchRepo(repo string) bool {
+	if strings.HasPrefix(p.RepoPattern, "^") {
+		return regexp.MustCompile(p.RepoPattern).MatchString(repo)
+	}
+	ok, _ := path.Match(p.RepoPattern, repo)
+	return ok
+}
+
+// clean repositories one after the other and print a summary per repository and the totals
+func (p Plugin) cleanRepos(repos []string, clean func(Plugin) (Summary, error)) error {
+	total := Summary{}
+	var summaries []Summary
+	var notCleaned []string
+	failed := 0
+	for i, repo := range repos {
+		// no new repository once stopped
+		if p.interrupted() != nil {
+			notCleaned = repos[i:]
+			break
+		}
+		p.Repo = repo
+		fmt.Printf("cleaning %s\n", repo)
+		summary, err := clean(p)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error cleaning %s: %s\n", repo, err)
+			failed++
+			continue
+		}
+		summaries = append(summaries, summary)
+		total.add(summary)
+	}
+	fmt.Println("summary:")
+	for _, s := range summaries {
+		fmt.Printf("  %s: kept %d, planned %d, dry run %d, deleted %d, errors %d, not deleted %d\n", s.Repo, s.Kept, s.Planned, s.DryRun, s.Deleted, s.Errors, s.Skipped)
+	}
+	if len(notCleaned) > 0 {
+		fmt.Printf("  not cleaned (%s): %s\n", p.interrupted(), strings.Join(notCleaned, ", "))
+	}
+	fmt.Printf("total %d repositories: kept %d, planned %d, dry run %d, deleted %d, errors %d, not deleted %d\n", len(repos), total.Kept, total.Planned, total.DryRun, total.Deleted, total.Errors, total.Skipped)
+	if failed > 0 {
+		return fmt.Errorf("could not clean %d repositories", failed)
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package github
+
+import "time"
+
+//Owner is a user or an organization
+type Owner struct {
+	Login string
+	// User or Organization
+	Type string
+}
+
+//Package is a package of an owner
+type Package struct {
+	ID          int64
+	Name        string
+	PackageType string `json:"package_type"`
+}
+
+//Version is a version of a package, the name of container versions is their digest
+type Version struct {
+	ID        int64
+	Name      string
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Metadata  Metadata
+}
+
+//Metadata is the metadata of a package version
+type Metadata struct {
+	PackageType string `json:"package_type"`
+	Container   Container
+}
+
+//Container is the metadata of a container version
+type Container struct {
+	Tags []string
+}
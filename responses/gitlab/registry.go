@@ -0,0 +1,23 @@
+package gitlab
+
+import "time"
+
+//Repository is a container registry repository of a project
+type Repository struct {
+	ID        int64
+	Name      string
+	Path      string
+	ProjectID int64 `json:"project_id"`
+	Location  string
+}
+
+//Tag is a tag of a container registry repository, only the details contain the digest and the creation time
+type Tag struct {
+	Name      string
+	Path      string
+	Location  string
+	Digest    string
+	Revision  string
+	CreatedAt time.Time `json:"created_at"`
+	TotalSize int64     `json:"total_size"`
+}
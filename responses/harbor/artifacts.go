@@ -0,0 +1,28 @@
+package harbor
+
+import "time"
+
+//Artifact is an artifact (image, index or chart) of a repository with its tags
+type Artifact struct {
+	ID       int64
+	Type     string
+	Digest   string
+	PushTime time.Time `json:"push_time"`
+	PullTime time.Time `json:"pull_time"`
+	Tags     []Tag
+	Labels   []Label
+}
+
+//Tag is a tag of an artifact
+type Tag struct {
+	ID       int64
+	Name     string
+	PushTime time.Time `json:"push_time"`
+	PullTime time.Time `json:"pull_time"`
+}
+
+//Label is a label of an artifact
+type Label struct {
+	ID   int64
+	Name string
+}
@@ -0,0 +1,12 @@
+package harbor
+
+import "time"
+
+//Repository is a repository, its name contains the project
+type Repository struct {
+	ID            int64
+	ProjectID     int64 `json:"project_id"`
+	Name          string
+	ArtifactCount int       `json:"artifact_count"`
+	UpdateTime    time.Time `json:"update_time"`
+}
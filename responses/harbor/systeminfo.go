@@ -0,0 +1,6 @@
+package harbor
+
+//SystemInfo is the general information of a harbor instance
+type SystemInfo struct {
+	HarborVersion string `json:"harbor_version"`
+}
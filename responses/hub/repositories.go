@@ -0,0 +1,19 @@
+package hub
+
+import "time"
+
+//Repositories is the repositories response
+type Repositories struct {
+	Count    int
+	Next     string
+	Previous string
+	Results  []Repository
+}
+
+//Repository is a repository
+type Repository struct {
+	Name        string
+	Namespace   string
+	IsPrivate   bool      `json:"is_private"`
+	LastUpdated time.Time `json:"last_updated"`
+}
@@ -22,6 +22,7 @@ type Tag struct {
 	LastUpdated time.Time `json:"last_updated"`
 	ImageID     int       `json:"image_id"`
 	V2          bool
+	Digest      string
 }
 
 //Image contains an image information
@@ -0,0 +1,6 @@
+package registry
+
+//CatalogResp is the catalog request response
+type CatalogResp struct {
+	Repositories []string
+}
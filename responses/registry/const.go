@@ -5,12 +5,24 @@ const (
 	ManifestMimeV2 = "application/vnd.docker.distribution.manifest.v2+json"
 	//ManifestMimeV1 mime type of manifests v1 format
 	ManifestMimeV1 = "application/vnd.docker.distribution.manifest.v1+prettyjws"
+	//ManifestListMimeV2 mime type of manifest lists (multi platform images)
+	ManifestListMimeV2 = "application/vnd.docker.distribution.manifest.list.v2+json"
+	//ManifestMimeOCI mime type of oci image manifests
+	ManifestMimeOCI = "application/vnd.oci.image.manifest.v1+json"
+	//IndexMimeOCI mime type of oci image indexes
+	IndexMimeOCI = "application/vnd.oci.image.index.v1+json"
+	//ManifestAccept accept header value for all handled manifest types
+	ManifestAccept = ManifestMimeOCI + ", " + IndexMimeOCI + ", " + ManifestListMimeV2 + ", " + ManifestMimeV2 + ", " + ManifestMimeV1
+	//AttestationAnnotation annotation indicating the type of reference in an index
+	AttestationAnnotation = "vnd.docker.reference.type"
+	//AttestationType reference type of build attestations
+	AttestationType = "attestation-manifest"
 	//AuthHeader registry authentication header
 	AuthHeader = "Www-Authenticate"
 	//DigestHeader registery digest header
 	DigestHeader = "Docker-Content-Digest"
-	//ValidAuthHeader regex to  validate auth header
-	ValidAuthHeader = "^[Bb]earer *((realm|service|scope|error)=\"[A-Za-z0-9-_./:]+\",?){2,4}$"
 	//Scope to delete tags
 	Scope = "pull,push,delete"
+	//CatalogScope scope to list the repositories
+	CatalogScope = "registry:catalog:*"
 )
@@ -19,6 +19,12 @@ type BlobInfo struct {
 	Digest    string
 }
 
+//ManifestListRespV2 is a manifest list request response
+type ManifestListRespV2 struct {
+	versioned
+	Manifests []ManifestInfo
+}
+
 //ManifestRespV1 is a manifest v1 request response
 type ManifestRespV1 struct {
 	versioned
@@ -32,3 +38,37 @@ type ManifestRespV1 struct {
 type History struct {
 	V1Compatibility string
 }
+
+//ManifestRespOCI is an oci image manifest request response
+type ManifestRespOCI struct {
+	versioned
+	Config BlobInfo
+	Layers []BlobInfo
+}
+
+//IndexRespOCI is an oci image index request response
+type IndexRespOCI struct {
+	versioned
+	Manifests []ManifestInfo
+}
+
+//ManifestInfo contains the informations about a manifest referenced by a list or an index
+type ManifestInfo struct {
+	MediaType   string
+	Size        int
+	Digest      string
+	Platform    Platform
+	Annotations map[string]string
+}
+
+//Platform describes the platform of a manifest
+type Platform struct {
+	Architecture string
+	OS           string
+	Variant      string
+}
+
+//IsAttestation checks if the manifest is a build attestation and not an image
+func (m ManifestInfo) IsAttestation() bool {
+	return m.Annotations[AttestationAnnotation] == AttestationType || m.Platform.OS == "unknown"
+}
@@ -0,0 +1,194 @@
+//   Copyright (c) 2019 cblomart
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package rest
+
+import (
+	"fmt"
+	"strings"
+)
+
+//Challenge is an authentication challenge of a WWW-Authenticate header (RFC 7235)
+type Challenge struct {
+	Scheme string
+	// parameters with lower case names
+	Parameters map[string]string
+	// token68 value for schemes not using parameters
+	Token string
+}
+
+//Is checks the scheme of the challenge (case insensitive)
+func (c Challenge) Is(scheme string) bool {
+	return strings.EqualFold(c.Scheme, scheme)
+}
+
+//FindChallenge returns the first challenge with the scheme
+func FindChallenge(challenges []Challenge, scheme string) (Challenge, bool) {
+	for _, c := range challenges {
+		if c.Is(scheme) {
+			return c, true
+		}
+	}
+	return Challenge{}, false
+}
+
+//ParseChallenges parses the values of WWW-Authenticate headers
+//a value can contain multiple challenges and quoted values can contain commas
+func ParseChallenges(values []string) ([]Challenge, error) {
+	var challenges []Challenge
+	for _, value := range values {
+		p := &challengeParser{s: value}
+		parsed, err := p.parse()
+		if err != nil {
+			return nil, fmt.Errorf("invalid authentication challenge (%s): %s", value, err)
+		}
+		challenges = append(challenges, parsed...)
+	}
+	return challenges, nil
+}
+
+// parser of a WWW-Authenticate header value
+type challengeParser struct {
+	s string
+	i int
+}
+
+// parse the challenges of the value
+func (p *challengeParser) parse() ([]Challenge, error) {
+	var challenges []Challenge
+	for {
+		p.skip(" \t,")
+		if p.i >= len(p.s) {
+			return challenges, nil
+		}
+		scheme := p.token()
+		if len(scheme) == 0 {
+			return nil, fmt.Errorf("expected scheme at %d", p.i)
+		}
+		challenge := Challenge{Scheme: scheme, Parameters: map[string]string{}}
+		// scheme without parameters
+		if p.i >= len(p.s) || p.s[p.i] == ',' {
+			challenges = append(challenges, challenge)
+			continue
+		}
+		p.skip(" \t")
+		// token68 value
+		if token, ok := p.token68(); ok {
+			challenge.Token = token
+			challenges = append(challenges, challenge)
+			continue
+		}
+		// parameters until the next challenge
+		for p.i < len(p.s) {
+			start := p.i
+			name := p.token()
+			if len(name) == 0 {
+				return nil, fmt.Errorf("expected parameter at %d", p.i)
+			}
+			p.skip(" \t")
+			if p.i >= len(p.s) || p.s[p.i] != '=' {
+				// a token without value starts the next challenge
+				p.i = start
+				break
+			}
+			p.i++
+			p.skip(" \t")
+			value, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			challenge.Parameters[strings.ToLower(name)] = value
+			p.skip(" \t")
+			if p.i < len(p.s) && p.s[p.i] != ',' {
+				return nil, fmt.Errorf("expected comma at %d", p.i)
+			}
+			p.skip(" \t,")
+		}
+		challenges = append(challenges, challenge)
+	}
+}
+
+// skip the characters in the set
+func (p *challengeParser) skip(set string) {
+	for p.i < len(p.s) && strings.IndexByte(set, p.s[p.i]) >= 0 {
+		p.i++
+	}
+}
+
+// read a token (RFC 7230)
+func (p *challengeParser) token() string {
+	start := p.i
+	for p.i < len(p.s) && isTokenChar(p.s[p.i]) {
+		p.i++
+	}
+	return p.s[start:p.i]
+}
+
+// read a token68 if the rest of the challenge is one
+func (p *challengeParser) token68() (string, bool) {
+	start := p.i
+	for p.i < len(p.s) && (isTokenChar(p.s[p.i]) || p.s[p.i] == '/') {
+		p.i++
+	}
+	for p.i < len(p.s) && p.s[p.i] == '=' {
+		p.i++
+	}
+	end := p.i
+	p.skip(" \t")
+	// a token68 is followed by the end or the next challenge
+	if end > start && (p.i >= len(p.s) || p.s[p.i] == ',') {
+		return p.s[start:end], true
+	}
+	p.i = start
+	return "", false
+}
+
+// read a parameter value: token or quoted string
+func (p *challengeParser) value() (string, error) {
+	if p.i < len(p.s) && p.s[p.i] == '"' {
+		p.i++
+		var b strings.Builder
+		for p.i < len(p.s) {
+			c := p.s[p.i]
+			switch c {
+			case '\\':
+				if p.i+1 >= len(p.s) {
+					return "", fmt.Errorf("unterminated escape at %d", p.i)
+				}
+				b.WriteByte(p.s[p.i+1])
+				p.i += 2
+				continue
+			case '"':
+				p.i++
+				return b.String(), nil
+			}
+			b.WriteByte(c)
+			p.i++
+		}
+		return "", fmt.Errorf("unterminated quoted string")
+	}
+	value := p.token()
+	if len(value) == 0 {
+		return "", fmt.Errorf("expected value at %d", p.i)
+	}
+	return value, nil
+}
+
+// check if a character is allowed in a token
+func isTokenChar(c byte) bool {
+	if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' {
+		return true
+	}
+	return strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0
+}
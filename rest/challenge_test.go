@@ -0,0 +1,83 @@
+//   Copyright (c) 2019 cblomart
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package rest
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseChallenges(t *testing.T) {
+	tests := []struct {
+		name     string
+		values   []string
+		expected []Challenge
+	}{
+		{
+			name:   "realm with port and query with commas",
+			values: []string{`Bearer realm="https://auth.example.com:5001/token?a=1,b=2",service="registry",scope="repository:team/app:pull,push"`},
+			expected: []Challenge{{Scheme: "Bearer", Parameters: map[string]string{
+				"realm":   "https://auth.example.com:5001/token?a=1,b=2",
+				"service": "registry",
+				"scope":   "repository:team/app:pull,push",
+			}}},
+		},
+		{
+			name:   "several challenges and token68",
+			values: []string{`Basic realm="registry", Bearer realm="https://auth.example.com/token",service=registry, Negotiate abc/123==`},
+			expected: []Challenge{
+				{Scheme: "Basic", Parameters: map[string]string{"realm": "registry"}},
+				{Scheme: "Bearer", Parameters: map[string]string{"realm": "https://auth.example.com/token", "service": "registry"}},
+				{Scheme: "Negotiate", Parameters: map[string]string{}, Token: "abc/123=="},
+			},
+		},
+		{
+			name:     "escaped quote",
+			values:   []string{`Basic realm="the \"registry\""`},
+			expected: []Challenge{{Scheme: "Basic", Parameters: map[string]string{"realm": `the "registry"`}}},
+		},
+		{
+			name:   "several headers",
+			values: []string{`Basic realm="registry"`, `Bearer realm="https://auth.example.com/token"`},
+			expected: []Challenge{
+				{Scheme: "Basic", Parameters: map[string]string{"realm": "registry"}},
+				{Scheme: "Bearer", Parameters: map[string]string{"realm": "https://auth.example.com/token"}},
+			},
+		},
+	}
+	for _, test := range tests {
+		challenges, err := ParseChallenges(test.values)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(challenges, test.expected) {
+			t.Errorf("%s: parsed %+v, expected %+v", test.name, challenges, test.expected)
+		}
+	}
+}
+
+func TestParseChallengesErrors(t *testing.T) {
+	for _, value := range []string{
+		`Bearer realm="https://auth.example.com/token`,
+		`Bearer realm="https://auth.example.com/token\`,
+		`Bearer realm="https://auth.example.com/token" service="registry"`,
+	} {
+		_, err := ParseChallenges([]string{value})
+		if err == nil {
+			t.Errorf("parsed invalid challenge %s", value)
+		}
+	}
+}
@@ -16,19 +16,23 @@ package rest
 
 import (
 	"bytes"
-	"crypto/tls"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
+	neturl "net/url"
 	"strings"
+	"time"
 )
 
 const (
-	jsonMmime         = "application/json"
-	headerContentType = "Content-Type"
-	headerAccept      = "Accept"
+	jsonMmime          = "application/json"
+	formMime           = "application/x-www-form-urlencoded"
+	headerContentType  = "Content-Type"
+	headerAccept       = "Accept"
+	headerAuthenticate = "Www-Authenticate"
 )
 
 //Client is a simple rest client
@@ -36,14 +40,18 @@ type Client struct {
 	client  *http.Client
 	Headers map[string]string
 	Dump    bool
+	// bearer token authentication
+	Bearer *Bearer
+	// retries of failed requests
+	Retry RetryPolicy
+	// request budget updated from the responses (can be shared between clients)
+	RateLimit *RateLimit
 }
 
-//NewClient create a rest client
-func NewClient(dump bool, insecure bool) *Client {
-	client := &http.Client{}
-	/* #nosec */
-	if insecure {
-		client.Transport.(*http.Transport).TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+//NewClient create a rest client sending the requests with the http client (default client if nil)
+func NewClient(dump bool, client *http.Client) *Client {
+	if client == nil {
+		client = &http.Client{}
 	}
 	return &Client{
 		client:  client,
@@ -52,19 +60,125 @@ func NewClient(dump bool, insecure bool) *Client {
 	}
 }
 
-func (c *Client) do(method string, url string, payload interface{}) ([]byte, error) {
+// copy of the client with an additional header
+func (c *Client) with(key string, value string) *Client {
+	copy := *c
+	copy.Headers = map[string]string{key: value}
+	for k, v := range c.Headers {
+		if k != key {
+			copy.Headers[k] = v
+		}
+	}
+	return &copy
+}
+
+func (c *Client) do(ctx context.Context, method string, url string, payload interface{}) ([]byte, http.Header, error) {
 	// be sure that method is in uppercase
 	method = strings.ToUpper(method)
+	response, err := c.send(ctx, method, url, payload)
+	if err != nil {
+		return []byte(""), nil, err
+	}
+	// an expired or revoked token is challenged again: renew it and retry once
+	if response.StatusCode == http.StatusUnauthorized && c.Bearer != nil {
+		challenges, _ := ParseChallenges(response.Header.Values(headerAuthenticate))
+		if _, ok := FindChallenge(challenges, "Bearer"); ok {
+			response.Body.Close()
+			c.Bearer.invalidate()
+			response, err = c.send(ctx, method, url, payload)
+			if err != nil {
+				return []byte(""), nil, err
+			}
+		}
+	}
+	defer response.Body.Close()
+	// dump response headers
+	if c.Dump {
+		fmt.Println("response headers ---")
+		for key, value := range response.Header {
+			fmt.Printf("%s: %s\n", key, strings.Join(value, "; "))
+		}
+		fmt.Println("response headers ---")
+	}
+	if method == "HEAD" {
+		body, err := json.Marshal(response.Header)
+		if err != nil {
+			return []byte(""), nil, fmt.Errorf("cannot marshal response headers")
+		}
+		if response.StatusCode >= 300 || response.StatusCode < 200 {
+			return body, response.Header, newError(method, url, response, nil)
+		}
+		return body, response.Header, nil
+	}
+	// read the response
+	data, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return []byte(""), nil, fmt.Errorf("cannot read response body")
+	}
+	if c.Dump {
+		fmt.Printf("response ---\n%s\nresponse ---\n", string(data))
+	}
+	if response.StatusCode >= 300 || response.StatusCode < 200 {
+		return data, response.Header, newError(method, url, response, data)
+	}
+	return data, response.Header, nil
+}
+
+// send a request, retrying the failed attempts following the retry policy
+func (c *Client) send(ctx context.Context, method string, url string, payload interface{}) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		response, sent, err := c.attempt(ctx, method, url, payload)
+		if response != nil && c.RateLimit != nil {
+			c.RateLimit.update(response.Header)
+		}
+		if !sent || attempt >= c.Retry.MaxAttempts {
+			return response, err
+		}
+		switch {
+		case err != nil && !idempotent(method):
+			return response, err
+		case err == nil && response.StatusCode == http.StatusTooManyRequests:
+		case err == nil && (!idempotent(method) || !transientStatus[response.StatusCode]):
+			return response, nil
+		}
+		wait := c.Retry.backoff(attempt)
+		if response != nil {
+			if after, ok := retryAfter(response.Header); ok {
+				if after > c.Retry.maxRetryAfter() {
+					return response, nil
+				}
+				wait = after
+			}
+			response.Body.Close()
+		}
+		if c.Dump {
+			fmt.Printf("retry > %s %s in %s (attempt %d/%d)\n", method, url, wait, attempt+1, c.Retry.MaxAttempts)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// send a request once, form values are sent url encoded and other payloads as json
+// sent is false when the request could not be prepared
+func (c *Client) attempt(ctx context.Context, method string, url string, payload interface{}) (*http.Response, bool, error) {
 	if c.Dump {
 		fmt.Printf("request > %s %s\n", method, url)
 	}
 	// create payload io reader
 	var reader io.Reader
-	if payload != nil {
+	contentType := jsonMmime
+	if form, ok := payload.(neturl.Values); ok {
+		reader = strings.NewReader(form.Encode())
+		contentType = formMime
+	} else if payload != nil {
 		// marshall payload
 		jsonpayload, err := json.Marshal(payload)
 		if err != nil {
-			return []byte(""), fmt.Errorf("cannot serialise payload")
+			return nil, false, fmt.Errorf("cannot serialise payload")
 		}
 		if c.Dump {
 			fmt.Printf("payload ---\njsonpayload\npayload ---")
@@ -72,13 +186,13 @@ func (c *Client) do(method string, url string, payload interface{}) ([]byte, err
 		reader = bytes.NewBuffer(jsonpayload)
 	}
 	// create the request
-	request, err := http.NewRequest(method, url, reader)
+	request, err := http.NewRequestWithContext(ctx, method, url, reader)
 	if err != nil {
-		return []byte(""), fmt.Errorf("cannot create request")
+		return nil, false, fmt.Errorf("cannot create request")
 	}
 	// set default rest headers
 	if payload != nil {
-		request.Header.Set(headerContentType, jsonMmime)
+		request.Header.Set(headerContentType, contentType)
 	}
 	if method != "HEAD" {
 		request.Header.Set(headerAccept, jsonMmime)
@@ -87,6 +201,14 @@ func (c *Client) do(method string, url string, payload interface{}) ([]byte, err
 	for key, value := range c.Headers {
 		request.Header.Set(key, value)
 	}
+	// authenticate with a valid token
+	if c.Bearer != nil {
+		token, err := c.Bearer.Token(ctx)
+		if err != nil {
+			return nil, false, err
+		}
+		request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	}
 	// dump request headers
 	if c.Dump {
 		fmt.Println("request headers ---")
@@ -105,40 +227,14 @@ func (c *Client) do(method string, url string, payload interface{}) ([]byte, err
 	// do the request
 	response, err := c.client.Do(request)
 	if err != nil {
-		return []byte(""), fmt.Errorf("error executing request")
-	}
-	// dump response headers
-	if c.Dump {
-		fmt.Println("response headers ---")
-		for key, value := range response.Header {
-			fmt.Printf("%s: %s\n", key, strings.Join(value, "; "))
-		}
-		fmt.Println("response headers ---")
-	}
-	if method == "HEAD" {
-		body, err := json.Marshal(response.Header)
-		if err != nil {
-			return []byte(""), fmt.Errorf("cannot marshal response headers")
-		}
-		return body, nil
-	}
-	// read the response
-	data, err := ioutil.ReadAll(response.Body)
-	if err != nil {
-		return []byte(""), fmt.Errorf("cannot read response body")
-	}
-	if c.Dump {
-		fmt.Printf("response ---\n%s\nresponse ---\n", string(data))
-	}
-	if response.StatusCode >= 300 || response.StatusCode < 200 {
-		return data, fmt.Errorf(response.Status)
+		return nil, true, fmt.Errorf("error executing request %s %s: %w", method, url, err)
 	}
-	return data, nil
+	return response, true, nil
 }
 
 //Get does a get request
-func (c *Client) Get(url string, payload interface{}, v interface{}) error {
-	data, err := c.do("GET", url, payload)
+func (c *Client) Get(ctx context.Context, url string, payload interface{}, v interface{}) error {
+	data, _, err := c.do(ctx, "GET", url, payload)
 	if err != nil {
 		return err
 	}
@@ -148,21 +244,34 @@ func (c *Client) Get(url string, payload interface{}, v interface{}) error {
 	return nil
 }
 
-//Head does a get request
-func (c *Client) Head(url string, payload interface{}, v interface{}) error {
-	data, err := c.do("HEAD", url, payload)
+//GetWithHeaders does a get request and returns the response headers
+func (c *Client) GetWithHeaders(ctx context.Context, url string, payload interface{}, v interface{}) (http.Header, error) {
+	data, headers, err := c.do(ctx, "GET", url, payload)
 	if err != nil {
-		return err
+		return headers, err
 	}
 	if v != nil {
-		return json.Unmarshal(data, v)
+		return headers, json.Unmarshal(data, v)
 	}
-	return nil
+	return headers, nil
+}
+
+//Head does a head request, the headers are decoded even for error responses (authentication challenges)
+func (c *Client) Head(ctx context.Context, url string, payload interface{}, v interface{}) error {
+	data, _, err := c.do(ctx, "HEAD", url, payload)
+	if v == nil || len(data) == 0 {
+		return err
+	}
+	decodeErr := json.Unmarshal(data, v)
+	if err != nil {
+		return err
+	}
+	return decodeErr
 }
 
 //Delete does a delete request
-func (c *Client) Delete(url string, payload interface{}, v interface{}) error {
-	data, err := c.do("DELETE", url, payload)
+func (c *Client) Delete(ctx context.Context, url string, payload interface{}, v interface{}) error {
+	data, _, err := c.do(ctx, "DELETE", url, payload)
 	if err != nil {
 		return err
 	}
@@ -173,8 +282,8 @@ func (c *Client) Delete(url string, payload interface{}, v interface{}) error {
 }
 
 //Post does a post request
-func (c *Client) Post(url string, payload interface{}, v interface{}) error {
-	data, err := c.do("POST", url, payload)
+func (c *Client) Post(ctx context.Context, url string, payload interface{}, v interface{}) error {
+	data, _, err := c.do(ctx, "POST", url, payload)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,41 @@
+//   Copyright (c) 2019 cblomart
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package rest
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHeadError(t *testing.T) {
+	for _, status := range []int{http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound, http.StatusInternalServerError} {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Www-Authenticate", `Bearer realm="https://auth.example.com/token"`)
+			w.WriteHeader(status)
+		}))
+		var headers map[string][]string
+		err := NewClient(false, srv.Client()).Head(context.Background(), srv.URL, nil, &headers)
+		srv.Close()
+		if !IsStatus(err, status) {
+			t.Errorf("head with status %d returned %v", status, err)
+		}
+		// the challenges of error responses are still readable
+		if len(headers["Www-Authenticate"]) != 1 {
+			t.Errorf("head with status %d decoded headers %v", status, headers)
+		}
+	}
+}
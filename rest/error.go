@@ -0,0 +1,107 @@
+//   Copyright (c) 2019 cblomart
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+//CodeUnsupported is the distribution error code of disabled operations (deletes)
+const CodeUnsupported = "UNSUPPORTED"
+
+type (
+	//Error is an error response of a server
+	Error struct {
+		Method     string
+		URL        string
+		StatusCode int
+		Status     string
+		// errors of the distribution api ({"errors":[...]})
+		Errors []ErrorDetail
+		// message of other error bodies (docker hub)
+		Message string
+	}
+
+	//ErrorDetail is an error of the distribution api
+	ErrorDetail struct {
+		Code    string          `json:"code"`
+		Message string          `json:"message"`
+		Detail  json.RawMessage `json:"detail,omitempty"`
+	}
+)
+
+// create the error of a response decoding the error body
+func newError(method string, url string, response *http.Response, body []byte) *Error {
+	e := &Error{Method: method, URL: url, StatusCode: response.StatusCode, Status: response.Status}
+	var decoded struct {
+		Errors  []ErrorDetail `json:"errors"`
+		Message string        `json:"message"`
+		// docker hub uses detail for most errors
+		Detail interface{} `json:"detail"`
+	}
+	if json.Unmarshal(body, &decoded) != nil {
+		return e
+	}
+	e.Errors = decoded.Errors
+	e.Message = decoded.Message
+	if detail, ok := decoded.Detail.(string); ok && len(e.Message) == 0 {
+		e.Message = detail
+	}
+	return e
+}
+
+//Error describes the error: request, status and messages of the body
+func (e *Error) Error() string {
+	var messages []string
+	for _, detail := range e.Errors {
+		messages = append(messages, fmt.Sprintf("%s %s", detail.Code, detail.Message))
+	}
+	if len(e.Message) > 0 {
+		messages = append(messages, e.Message)
+	}
+	if len(messages) == 0 {
+		return fmt.Sprintf("%s %s: %s", e.Method, e.URL, e.Status)
+	}
+	return fmt.Sprintf("%s %s: %s (%s)", e.Method, e.URL, e.Status, strings.Join(messages, ", "))
+}
+
+//HasCode checks if the distribution errors contain the code
+func (e *Error) HasCode(code string) bool {
+	for _, detail := range e.Errors {
+		if detail.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+//AsError returns the server error in the chain of an error
+func AsError(err error) (*Error, bool) {
+	var e *Error
+	if errors.As(err, &e) {
+		return e, true
+	}
+	return nil, false
+}
+
+//IsStatus checks if an error is a server error with the status code
+func IsStatus(err error, status int) bool {
+	e, ok := AsError(err)
+	return ok && e.StatusCode == status
+}
@@ -0,0 +1,63 @@
+//   Copyright (c) 2019 cblomart
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package rest
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const headerLink = "Link"
+
+//NextLink returns the url of the next page from the Link headers (RFC 5988)
+//relative links are resolved against the url of the current page, empty if no next page
+func NextLink(headers http.Header, current string) string {
+	for _, value := range headers[headerLink] {
+		for _, link := range strings.Split(value, ",") {
+			parts := strings.Split(link, ";")
+			target := strings.TrimSpace(parts[0])
+			if !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+				continue
+			}
+			target = target[1 : len(target)-1]
+			for _, param := range parts[1:] {
+				kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+				if len(kv) != 2 || !strings.EqualFold(kv[0], "rel") {
+					continue
+				}
+				for _, rel := range strings.Fields(strings.Trim(kv[1], "\"")) {
+					if strings.EqualFold(rel, "next") {
+						return resolve(current, target)
+					}
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// resolve a reference against a base url
+func resolve(base string, ref string) string {
+	baseurl, err := url.Parse(base)
+	if err != nil {
+		return ref
+	}
+	refurl, err := url.Parse(ref)
+	if err != nil {
+		return ref
+	}
+	return baseurl.ResolveReference(refurl).String()
+}
@@ -0,0 +1,189 @@
+//   Copyright (c) 2019 cblomart
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package rest
+
+import (
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	headerRetryAfter = "Retry-After"
+	// maximum wait between attempts when not configured
+	defaultMaxBackoff = 30 * time.Second
+	// longest retry-after honored when not configured, longer ones fail the request
+	defaultMaxRetryAfter = 5 * time.Minute
+)
+
+// rate limit headers: docker hub api (X-RateLimit-*) and registry (RateLimit-*)
+var (
+	rateLimitHeaders     = []string{"X-RateLimit-Limit", "RateLimit-Limit"}
+	rateRemainingHeaders = []string{"X-RateLimit-Remaining", "RateLimit-Remaining"}
+	rateResetHeaders     = []string{"X-RateLimit-Reset", "RateLimit-Reset"}
+)
+
+// status codes of transient failures
+var transientStatus = map[int]bool{
+	http.StatusTooManyRequests:     true,
+	http.StatusInternalServerError: true,
+	http.StatusBadGateway:          true,
+	http.StatusServiceUnavailable:  true,
+	http.StatusGatewayTimeout:      true,
+}
+
+// random source of the backoff jitter
+var (
+	jitter     = rand.New(rand.NewSource(time.Now().UnixNano())) // #nosec
+	jitterLock sync.Mutex
+)
+
+type (
+	//RetryPolicy defines how failed requests are retried
+	//idempotent requests are retried on network errors and transient statuses, others only when refused with 429
+	RetryPolicy struct {
+		// attempts of a request, the first included (0 or 1 for no retry)
+		MaxAttempts int
+		// wait before the first retry, doubled at each retry
+		Backoff    time.Duration
+		MaxBackoff time.Duration
+		// longest Retry-After honored
+		MaxRetryAfter time.Duration
+	}
+
+	//RateLimit tracks the request budget announced by the servers
+	RateLimit struct {
+		known     bool
+		limit     int
+		remaining int
+		reset     time.Time
+		lock      sync.Mutex
+	}
+)
+
+// get the wait before a retry with equal jitter: between half and all of the exponential backoff
+func (rp RetryPolicy) backoff(retry int) time.Duration {
+	max := rp.MaxBackoff
+	if max <= 0 {
+		max = defaultMaxBackoff
+	}
+	wait := rp.Backoff
+	for i := 1; i < retry && wait < max; i++ {
+		wait *= 2
+	}
+	if wait > max {
+		wait = max
+	}
+	if wait <= 1 {
+		return wait
+	}
+	jitterLock.Lock()
+	defer jitterLock.Unlock()
+	return wait/2 + time.Duration(jitter.Int63n(int64(wait/2)))
+}
+
+// get the longest Retry-After honored
+func (rp RetryPolicy) maxRetryAfter() time.Duration {
+	if rp.MaxRetryAfter <= 0 {
+		return defaultMaxRetryAfter
+	}
+	return rp.MaxRetryAfter
+}
+
+// check if a method can be sent twice without side effect
+func idempotent(method string) bool {
+	return method != "POST" && method != "PATCH"
+}
+
+// get the wait requested by a Retry-After header (seconds or http date)
+func retryAfter(headers http.Header) (time.Duration, bool) {
+	value := headers.Get(headerRetryAfter)
+	if len(value) == 0 {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return time.Until(date), true
+	}
+	return 0, false
+}
+
+// get the first header of the list as integer, values can carry a window (100;w=21600)
+func headerInt(headers http.Header, names []string) (int, bool) {
+	for _, name := range names {
+		value := headers.Get(name)
+		if len(value) == 0 {
+			continue
+		}
+		value = strings.TrimSpace(strings.SplitN(value, ";", 2)[0])
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			continue
+		}
+		return n, true
+	}
+	return 0, false
+}
+
+// update the budget from the headers of a response
+func (rl *RateLimit) update(headers http.Header) {
+	remaining, ok := headerInt(headers, rateRemainingHeaders)
+	if !ok {
+		return
+	}
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+	rl.known = true
+	rl.remaining = remaining
+	if limit, ok := headerInt(headers, rateLimitHeaders); ok {
+		rl.limit = limit
+	}
+	rl.reset = time.Time{}
+	if reset, ok := headerInt(headers, rateResetHeaders); ok {
+		// the reset is a unix time on docker hub, a number of seconds otherwise
+		if int64(reset) > time.Now().Unix()/2 {
+			rl.reset = time.Unix(int64(reset), 0)
+		} else {
+			rl.reset = time.Now().Add(time.Duration(reset) * time.Second)
+		}
+	}
+}
+
+//Remaining returns the remaining requests, the limit and when the budget resets (zero if unknown)
+//ok is false when no server announced a budget
+func (rl *RateLimit) Remaining() (remaining int, limit int, reset time.Time, ok bool) {
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+	return rl.remaining, rl.limit, rl.reset, rl.known
+}
+
+//Low checks if the remaining budget is below the threshold and returns when it resets
+//a budget past its reset is not low anymore
+func (rl *RateLimit) Low(threshold int) (bool, time.Time) {
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+	if !rl.known || rl.remaining >= threshold {
+		return false, time.Time{}
+	}
+	if !rl.reset.IsZero() && time.Now().After(rl.reset) {
+		return false, time.Time{}
+	}
+	return true, rl.reset
+}
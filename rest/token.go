@@ -0,0 +1,217 @@
+//   Copyright (c) 2019 cblomart
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package rest
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/cblomart/registry-cleanup/responses/registry"
+)
+
+const (
+	// lifetime of a token without expires_in (docker token specification)
+	defaultTokenLifetime = 60 * time.Second
+	// tokens are renewed this long before they expire (at most half their lifetime)
+	tokenRenewal = 30 * time.Second
+	// client id sent to the authorization service
+	tokenClientID = "registry-cleanup"
+)
+
+type (
+	//TokenManager gets the bearer tokens of authorization services and caches them per realm, service and scope
+	//tokens are renewed before they expire, with their refresh token when one was issued
+	TokenManager struct {
+		client   *Client
+		username string
+		password string
+		// refresh token used for the first token of every scope (docker identity token)
+		identity string
+		tokens   map[tokenKey]*token
+		lock     sync.Mutex
+	}
+
+	//Bearer authenticates the requests of a client with the tokens of a token manager
+	Bearer struct {
+		Tokens  *TokenManager
+		Realm   string
+		Service string
+		Scope   string
+	}
+
+	// cache key of a token
+	tokenKey struct {
+		realm   string
+		service string
+		scope   string
+	}
+
+	// cached token
+	token struct {
+		value   string
+		refresh string
+		renew   time.Time
+	}
+)
+
+//NewTokenManager creates a token manager requesting tokens with the client and the credentials
+//the credentials can be empty for anonymous tokens
+func NewTokenManager(client *Client, username string, password string) *TokenManager {
+	return &TokenManager{
+		client:   client,
+		username: username,
+		password: password,
+		tokens:   map[tokenKey]*token{},
+	}
+}
+
+//UseIdentityToken requests the tokens with the refresh token of a docker identity token instead of the credentials
+func (m *TokenManager) UseIdentityToken(identity string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.identity = identity
+}
+
+//Token returns a valid token for the scope, requesting or renewing it when needed
+func (m *TokenManager) Token(ctx context.Context, realm string, service string, scope string) (string, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	key := tokenKey{realm: realm, service: service, scope: scope}
+	cached, ok := m.tokens[key]
+	if ok && time.Now().Before(cached.renew) {
+		return cached.value, nil
+	}
+	var t *token
+	var err error
+	// renew with the refresh token, the credentials are used if it was refused and there are some
+	if ok && len(cached.refresh) > 0 {
+		t, err = m.refresh(ctx, key, cached.refresh)
+	} else if len(m.identity) > 0 {
+		t, err = m.refresh(ctx, key, m.identity)
+	}
+	if t == nil && (err == nil || len(m.username) > 0) {
+		t, err = m.request(ctx, key)
+	}
+	if err != nil {
+		delete(m.tokens, key)
+		return "", err
+	}
+	m.tokens[key] = t
+	return t.value, nil
+}
+
+//Invalidate removes a token from the cache so that the next call renews it
+func (m *TokenManager) Invalidate(realm string, service string, scope string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	key := tokenKey{realm: realm, service: service, scope: scope}
+	if cached, ok := m.tokens[key]; ok {
+		// keep the refresh token for the renewal
+		cached.renew = time.Time{}
+	}
+}
+
+// request a token with the credentials (GET of the docker token specification)
+func (m *TokenManager) request(ctx context.Context, key tokenKey) (*token, error) {
+	tokenurl, err := url.Parse(key.realm)
+	if err != nil {
+		return nil, fmt.Errorf("realm is not in url format (%s)", key.realm)
+	}
+	query := tokenurl.Query()
+	if len(key.service) > 0 {
+		query.Set("service", key.service)
+	}
+	if len(key.scope) > 0 {
+		query.Set("scope", key.scope)
+	}
+	client := m.client
+	if len(m.username) > 0 {
+		// ask for a refresh token to renew without the credentials
+		query.Set("offline_token", "true")
+		query.Set("client_id", tokenClientID)
+		userpass := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s", m.username, m.password)))
+		client = client.with("Authorization", fmt.Sprintf("Basic %s", userpass))
+	}
+	tokenurl.RawQuery = query.Encode()
+	var resp registry.TokenResp
+	err = client.Get(ctx, tokenurl.String(), nil, &resp)
+	if err != nil {
+		return nil, fmt.Errorf("could not get token: %w", err)
+	}
+	return newToken(resp, "")
+}
+
+// renew a token with a refresh token (POST of the oauth2 token endpoint)
+func (m *TokenManager) refresh(ctx context.Context, key tokenKey, refresh string) (*token, error) {
+	form := url.Values{}
+	form.Set("grant_type", "refresh_token")
+	form.Set("refresh_token", refresh)
+	form.Set("client_id", tokenClientID)
+	if len(key.service) > 0 {
+		form.Set("service", key.service)
+	}
+	if len(key.scope) > 0 {
+		form.Set("scope", key.scope)
+	}
+	var resp registry.TokenResp
+	err := m.client.Post(ctx, key.realm, form, &resp)
+	if err != nil {
+		return nil, fmt.Errorf("could not refresh token: %w", err)
+	}
+	return newToken(resp, refresh)
+}
+
+// create a cached token from a token response
+func newToken(resp registry.TokenResp, refresh string) (*token, error) {
+	// oauth2 token endpoints answer with an access token
+	value := resp.Token
+	if len(value) == 0 {
+		value = resp.AccessToken
+	}
+	if len(value) == 0 {
+		return nil, fmt.Errorf("no token in response")
+	}
+	// a refresh token is only sent when it changes
+	if len(resp.RefreshToken) > 0 {
+		refresh = resp.RefreshToken
+	}
+	lifetime := time.Duration(resp.ExpiresIn) * time.Second
+	if lifetime <= 0 {
+		lifetime = defaultTokenLifetime
+	}
+	issued, err := time.Parse(time.RFC3339, resp.IssuedAt)
+	if err != nil || issued.After(time.Now()) {
+		issued = time.Now()
+	}
+	renewal := tokenRenewal
+	if renewal > lifetime/2 {
+		renewal = lifetime / 2
+	}
+	return &token{value: value, refresh: refresh, renew: issued.Add(lifetime - renewal)}, nil
+}
+
+//Token returns the token of the bearer scope
+func (b *Bearer) Token(ctx context.Context) (string, error) {
+	return b.Tokens.Token(ctx, b.Realm, b.Service, b.Scope)
+}
+
+// invalidate the token of the bearer scope
+func (b *Bearer) invalidate() {
+	b.Tokens.Invalidate(b.Realm, b.Service, b.Scope)
+}
@@ -0,0 +1,185 @@
+//   Copyright (c) 2019 cblomart
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fake authorization service issuing numbered tokens
+type fakeTokenServer struct {
+	// tokens are issued this long ago
+	age time.Duration
+	// refresh token issued with the credentials, refused on refresh when empty
+	refresh  string
+	lock     sync.Mutex
+	requests []string
+}
+
+func (f *fakeTokenServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	err := r.ParseForm()
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	resp := map[string]interface{}{
+		"token":      fmt.Sprintf("token%d", len(f.requests)+1),
+		"expires_in": 300,
+		"issued_at":  time.Now().Add(-f.age).UTC().Format(time.RFC3339),
+	}
+	switch r.Method {
+	case http.MethodGet:
+		user, password, _ := r.BasicAuth()
+		f.requests = append(f.requests, fmt.Sprintf("GET %s:%s offline_token=%s scope=%s", user, password, r.Form.Get("offline_token"), r.Form.Get("scope")))
+		if len(f.refresh) > 0 {
+			resp["refresh_token"] = f.refresh
+		}
+	case http.MethodPost:
+		f.requests = append(f.requests, fmt.Sprintf("POST grant_type=%s refresh_token=%s scope=%s", r.Form.Get("grant_type"), r.Form.Get("refresh_token"), r.Form.Get("scope")))
+		if len(f.refresh) == 0 || r.Form.Get("refresh_token") != f.refresh {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+	}
+	err = json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// get tokens of a scope from a fake authorization service
+func getTokens(t *testing.T, fake *fakeTokenServer, m func(*Client) *TokenManager, scopes ...string) []string {
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	tokens := m(NewClient(false, srv.Client()))
+	var values []string
+	for _, scope := range scopes {
+		value, err := tokens.Token(context.Background(), srv.URL, "registry", scope)
+		if err != nil {
+			values = append(values, err.Error())
+			continue
+		}
+		values = append(values, value)
+	}
+	return values
+}
+
+// token manager with credentials
+func withCredentials(c *Client) *TokenManager {
+	return NewTokenManager(c, "user", "secret")
+}
+
+func TestTokenReused(t *testing.T) {
+	fake := &fakeTokenServer{}
+	values := getTokens(t, fake, withCredentials, "repository:a:pull", "repository:a:pull", "repository:b:pull")
+	// tokens are cached per scope until they expire
+	if !reflect.DeepEqual(values, []string{"token1", "token1", "token2"}) {
+		t.Errorf("got tokens %v", values)
+	}
+	expected := []string{
+		"GET user:secret offline_token=true scope=repository:a:pull",
+		"GET user:secret offline_token=true scope=repository:b:pull",
+	}
+	if !reflect.DeepEqual(fake.requests, expected) {
+		t.Errorf("requested %v, expected %v", fake.requests, expected)
+	}
+}
+
+func TestTokenRenewed(t *testing.T) {
+	// the tokens are issued expired
+	fake := &fakeTokenServer{age: time.Hour}
+	values := getTokens(t, fake, withCredentials, "repository:a:pull", "repository:a:pull")
+	if !reflect.DeepEqual(values, []string{"token1", "token2"}) {
+		t.Errorf("got tokens %v", values)
+	}
+	if len(fake.requests) != 2 {
+		t.Errorf("requested %v, expected 2 requests", fake.requests)
+	}
+}
+
+func TestTokenRefreshGrant(t *testing.T) {
+	fake := &fakeTokenServer{age: time.Hour, refresh: "refresh1"}
+	values := getTokens(t, fake, withCredentials, "repository:a:pull", "repository:a:pull")
+	if !reflect.DeepEqual(values, []string{"token1", "token2"}) {
+		t.Errorf("got tokens %v", values)
+	}
+	// the renewal uses the refresh token instead of the credentials
+	expected := []string{
+		"GET user:secret offline_token=true scope=repository:a:pull",
+		"POST grant_type=refresh_token refresh_token=refresh1 scope=repository:a:pull",
+	}
+	if !reflect.DeepEqual(fake.requests, expected) {
+		t.Errorf("requested %v, expected %v", fake.requests, expected)
+	}
+}
+
+func TestTokenRefreshRefused(t *testing.T) {
+	fake := &fakeTokenServer{age: time.Hour, refresh: "refresh1"}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	tokens := withCredentials(NewClient(false, srv.Client()))
+	_, err := tokens.Token(context.Background(), srv.URL, "registry", "repository:a:pull")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the refresh token is revoked: the credentials are used again
+	fake.lock.Lock()
+	fake.refresh = ""
+	fake.lock.Unlock()
+	value, err := tokens.Token(context.Background(), srv.URL, "registry", "repository:a:pull")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != "token3" || len(fake.requests) != 3 || fake.requests[2] != "GET user:secret offline_token=true scope=repository:a:pull" {
+		t.Errorf("got %s after %v", value, fake.requests)
+	}
+}
+
+func TestTokenIdentity(t *testing.T) {
+	withIdentity := func(identity string) func(*Client) *TokenManager {
+		return func(c *Client) *TokenManager {
+			tokens := NewTokenManager(c, "", "")
+			tokens.UseIdentityToken(identity)
+			return tokens
+		}
+	}
+	// the identity token is the refresh token of the first token
+	fake := &fakeTokenServer{refresh: "identity"}
+	values := getTokens(t, fake, withIdentity("identity"), "repository:a:pull")
+	if !reflect.DeepEqual(values, []string{"token1"}) {
+		t.Errorf("got tokens %v", values)
+	}
+	expected := []string{"POST grant_type=refresh_token refresh_token=identity scope=repository:a:pull"}
+	if !reflect.DeepEqual(fake.requests, expected) {
+		t.Errorf("requested %v, expected %v", fake.requests, expected)
+	}
+	// without credentials a refused identity token is an error, not an anonymous token
+	fake = &fakeTokenServer{refresh: "identity"}
+	values = getTokens(t, fake, withIdentity("revoked"), "repository:a:pull")
+	if !strings.Contains(values[0], "could not refresh token") || len(fake.requests) != 1 {
+		t.Errorf("got %v after %v", values, fake.requests)
+	}
+}
@@ -0,0 +1,207 @@
+//   Copyright (c) 2019 cblomart
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package rest
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+//TransportConfig configures the connections of the http clients
+type TransportConfig struct {
+	// skip TLS verification
+	Insecure bool
+	// certificate authorities added to the system ones: a pem file and a directory of pem files
+	CAFile string
+	CADir  string
+	// client certificate and key for mutual TLS
+	ClientCert string
+	ClientKey  string
+	// minimum TLS version (1.0, 1.1, 1.2 or 1.3)
+	MinTLS string
+	// proxy (http, https or socks5 url) and hosts reached directly, the environment is used without proxy
+	Proxy   string
+	NoProxy string
+	// timeout of a request, response body included
+	Timeout time.Duration
+}
+
+// TLS versions per name
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+//NewHTTPClient creates an http client following the transport config
+func NewHTTPClient(config TransportConfig) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	tlsConfig := &tls.Config{}
+	/* #nosec */
+	if config.Insecure {
+		tlsConfig.InsecureSkipVerify = true
+	}
+	// trusted certificate authorities
+	if len(config.CAFile) > 0 || len(config.CADir) > 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		files := []string{}
+		if len(config.CAFile) > 0 {
+			files = append(files, config.CAFile)
+		}
+		if len(config.CADir) > 0 {
+			for _, pattern := range []string{"*.crt", "*.pem"} {
+				matches, err := filepath.Glob(filepath.Join(config.CADir, pattern))
+				if err != nil {
+					return nil, fmt.Errorf("cannot list certificates of %s", config.CADir)
+				}
+				files = append(files, matches...)
+			}
+			if len(files) == 0 {
+				return nil, fmt.Errorf("no certificate (.crt or .pem) in %s", config.CADir)
+			}
+		}
+		for _, file := range files {
+			/* #nosec */
+			data, err := ioutil.ReadFile(file)
+			if err != nil {
+				return nil, fmt.Errorf("cannot read certificate %s", file)
+			}
+			if !pool.AppendCertsFromPEM(data) {
+				return nil, fmt.Errorf("no pem certificate in %s", file)
+			}
+		}
+		tlsConfig.RootCAs = pool
+	}
+	// client certificate
+	if len(config.ClientCert) > 0 || len(config.ClientKey) > 0 {
+		if len(config.ClientCert) == 0 || len(config.ClientKey) == 0 {
+			return nil, fmt.Errorf("client certificate requires a certificate and a key")
+		}
+		cert, err := tls.LoadX509KeyPair(config.ClientCert, config.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load client certificate %s: %s", config.ClientCert, err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	if len(config.MinTLS) > 0 {
+		version, ok := tlsVersions[config.MinTLS]
+		if !ok {
+			return nil, fmt.Errorf("unknown TLS version %s (1.0, 1.1, 1.2 or 1.3)", config.MinTLS)
+		}
+		tlsConfig.MinVersion = version
+	}
+	transport.TLSClientConfig = tlsConfig
+	// proxy
+	if len(config.Proxy) > 0 {
+		proxy, err := url.Parse(config.Proxy)
+		if err != nil || len(proxy.Host) == 0 {
+			return nil, fmt.Errorf("proxy is not in url format (%s)", config.Proxy)
+		}
+		switch proxy.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return nil, fmt.Errorf("unsupported proxy scheme %s (http, https or socks5)", proxy.Scheme)
+		}
+		noProxy := parseNoProxy(config.NoProxy)
+		transport.Proxy = func(request *http.Request) (*url.URL, error) {
+			if noProxy.matches(request.URL) {
+				return nil, nil
+			}
+			return proxy, nil
+		}
+	}
+	return &http.Client{Transport: transport, Timeout: config.Timeout}, nil
+}
+
+// hosts reached without proxy
+type noProxy struct {
+	all      bool
+	networks []*net.IPNet
+	ips      []net.IP
+	// domains with an optional port, matching their sub domains
+	domains []string
+}
+
+// parse a NO_PROXY list: hosts, domains (.example.com), ips, cidr ranges and * for all
+func parseNoProxy(value string) noProxy {
+	var np noProxy
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		switch {
+		case len(entry) == 0:
+		case entry == "*":
+			np.all = true
+		default:
+			if _, network, err := net.ParseCIDR(entry); err == nil {
+				np.networks = append(np.networks, network)
+			} else if ip := net.ParseIP(entry); ip != nil {
+				np.ips = append(np.ips, ip)
+			} else {
+				np.domains = append(np.domains, strings.TrimPrefix(entry, "*"))
+			}
+		}
+	}
+	return np
+}
+
+// check if an url is reached without proxy
+func (np noProxy) matches(u *url.URL) bool {
+	if np.all {
+		return true
+	}
+	host := strings.ToLower(u.Hostname())
+	port := u.Port()
+	if len(port) == 0 {
+		port = map[string]string{"http": "80", "https": "443"}[u.Scheme]
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		for _, network := range np.networks {
+			if network.Contains(ip) {
+				return true
+			}
+		}
+		for _, other := range np.ips {
+			if other.Equal(ip) {
+				return true
+			}
+		}
+	}
+	for _, domain := range np.domains {
+		// an entry with a port only matches that port
+		if h, p, err := net.SplitHostPort(domain); err == nil {
+			if p != port {
+				continue
+			}
+			domain = h
+		}
+		domain = strings.TrimPrefix(domain, ".")
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,189 @@
+//   Copyright (c) 2019 cblomart
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+//Image groups the tags sharing the same digest
+type Image struct {
+	Digest  string
+	Created time.Time
+	Tags    []string
+	// reason of the retention decision
+	Reason string
+	// tags outside of the deletion set referencing the digest
+	SavedBy []string
+}
+
+//Name returns the tags of the image
+func (i Image) Name() string {
+	return strings.Join(i.Tags, ",")
+}
+
+// group tags per digest and order them per date (newer to older)
+// tags without digest are considered as distinct images
+func images(tags []Tag) []Image {
+	var result []Image
+	index := map[string]int{}
+	for _, tag := range tags {
+		if len(tag.Digest) > 0 {
+			if i, ok := index[tag.Digest]; ok {
+				result[i].Tags = append(result[i].Tags, tag.Name)
+				if tag.Created.After(result[i].Created) {
+					result[i].Created = tag.Created
+				}
+				continue
+			}
+			index[tag.Digest] = len(result)
+		}
+		result = append(result, Image{Digest: tag.Digest, Created: tag.Created, Tags: []string{tag.Name}})
+	}
+	for i := range result {
+		sort.Strings(result[i].Tags)
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Created.After(result[j].Created)
+	})
+	return result
+}
+
+// plan the deletions of the tags of a repository following the policy
+// references lists all the tags of the repository per digest
+// returns the images to delete and the images kept by the rules or saved by a reference
+func (p Plugin) plan(repo string, tags []Tag, references map[string][]string) ([]Image, []Image) {
+	rules := p.policy.Rules
+	// tags per rule and retention group
+	matched := make([]map[string][]Tag, len(rules))
+	for _, tag := range tags {
+		i := p.match(repo, tag.Name)
+		if i < 0 || rules[i].protects(tag.Name) {
+			continue
+		}
+		if matched[i] == nil {
+			matched[i] = map[string][]Tag{}
+		}
+		group := rules[i].group(tag.Name)
+		matched[i][group] = append(matched[i][group], tag)
+	}
+	var deletions, kept []Image
+	for i, rule := range rules {
+		// handle groups in a stable order
+		groups := make([]string, 0, len(matched[i]))
+		for group := range matched[i] {
+			groups = append(groups, group)
+		}
+		sort.Strings(groups)
+		for _, group := range groups {
+			images := images(matched[i][group])
+			if p.Verbose {
+				if len(group) > 0 {
+					fmt.Printf("rule %s matched %d tags/images in %s (group %s)\n", rule.Name, len(images), repo, group)
+				} else {
+					fmt.Printf("rule %s matched %d tags/images in %s\n", rule.Name, len(images), repo)
+				}
+			}
+			k, d := rule.selectImages(images)
+			kept = append(kept, k...)
+			deletions = append(deletions, d...)
+		}
+	}
+	deletions, saved := protect(deletions, references)
+	return deletions, append(kept, saved...)
+}
+
+// select the images to keep and to delete following the strategy of the rule
+func (r Rule) selectImages(images []Image) ([]Image, []Image) {
+	if r.Order == OrderSemver {
+		return r.selectVersions(images)
+	}
+	if r.isGFS() {
+		return r.selectBuckets(images)
+	}
+	return r.selectCreated(images)
+}
+
+// select the images to delete: the keep newest images are kept as well as images newer than max age
+func (r Rule) selectCreated(images []Image) ([]Image, []Image) {
+	var kept, deletions []Image
+	treshold := time.Now().Add(-time.Duration(r.MaxAge))
+	for i, image := range images {
+		if i < r.Keep {
+			image.Reason = fmt.Sprintf("within the %d newest", r.Keep)
+			kept = append(kept, image)
+			continue
+		}
+		if image.Created.Before(treshold) {
+			image.Reason = r.expired()
+			deletions = append(deletions, image)
+			continue
+		}
+		image.Reason = fmt.Sprintf("newer than %s", time.Duration(r.MaxAge))
+		kept = append(kept, image)
+	}
+	return kept, deletions
+}
+
+// reason of the deletion of an image not kept by the rule
+func (r Rule) expired() string {
+	if r.MaxAge == 0 {
+		return "not retained"
+	}
+	return fmt.Sprintf("older than %s", time.Duration(r.MaxAge))
+}
+
+// protect images whose digest is referenced by tags outside of the deletion set
+// references lists all the tags of the repository per digest
+func protect(deletions []Image, references map[string][]string) ([]Image, []Image) {
+	// merge the images selected by different rules
+	var merged []Image
+	index := map[string]int{}
+	for _, image := range deletions {
+		if len(image.Digest) > 0 {
+			if i, ok := index[image.Digest]; ok {
+				merged[i].Tags = append(merged[i].Tags, image.Tags...)
+				sort.Strings(merged[i].Tags)
+				continue
+			}
+			index[image.Digest] = len(merged)
+		}
+		merged = append(merged, image)
+	}
+	var deletable, saved []Image
+	for _, image := range merged {
+		selected := map[string]bool{}
+		for _, tag := range image.Tags {
+			selected[tag] = true
+		}
+		image.SavedBy = nil
+		for _, tag := range references[image.Digest] {
+			if !selected[tag] {
+				image.SavedBy = append(image.SavedBy, tag)
+			}
+		}
+		if len(image.SavedBy) > 0 {
+			sort.Strings(image.SavedBy)
+			image.Reason = fmt.Sprintf("referenced by %s", strings.Join(image.SavedBy, ","))
+			saved = append(saved, image)
+			continue
+		}
+		deletable = append(deletable, image)
+	}
+	return deletable, saved
+}
@@ -0,0 +1,80 @@
+//   Copyright (c) 2019 cblomart
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// start the contexts of a run and returns the function releasing them
+// the timeout cancels everything, a first SIGINT/SIGTERM stops the reads and the scheduling of deletions
+// while the deletions in progress finish, a second one cancels them too
+func (p *Plugin) start() func() {
+	deleteCtx, cancelDeletes := context.WithCancel(context.Background())
+	if p.Timeout > 0 {
+		var cancelTimeout context.CancelFunc
+		deleteCtx, cancelTimeout = context.WithTimeout(deleteCtx, p.Timeout)
+		cancelDeletes = cancelAll(cancelDeletes, cancelTimeout)
+	}
+	ctx, stop := context.WithCancel(deleteCtx)
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-signals:
+			fmt.Fprintf(os.Stderr, "received %s: stopping after the deletions in progress\n", sig)
+			stop()
+		case <-deleteCtx.Done():
+			return
+		}
+		select {
+		case sig := <-signals:
+			fmt.Fprintf(os.Stderr, "received %s again: cancelling the deletions in progress\n", sig)
+			cancelDeletes()
+		case <-deleteCtx.Done():
+		}
+	}()
+	p.ctx = ctx
+	p.deleteCtx = deleteCtx
+	return func() {
+		signal.Stop(signals)
+		stop()
+		cancelDeletes()
+	}
+}
+
+// combine cancel functions
+func cancelAll(cancels ...context.CancelFunc) context.CancelFunc {
+	return func() {
+		for _, cancel := range cancels {
+			cancel()
+		}
+	}
+}
+
+// get the reason the run stopped before its end (nil if it did not)
+func (p Plugin) interrupted() error {
+	if p.ctx == nil || p.ctx.Err() == nil {
+		return nil
+	}
+	if p.deleteCtx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("timeout of %s reached", p.Timeout)
+	}
+	return fmt.Errorf("interrupted")
+}
@@ -0,0 +1,249 @@
+//   Copyright (c) 2019 cblomart
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	//OrderCreated orders tags per creation date
+	OrderCreated = "created"
+	//OrderSemver orders tags per semantic version
+	OrderSemver = "semver"
+)
+
+// semantic version 2.0.0 with an optional v prefix
+var semverRegex = regexp.MustCompile(`^v?(0|[1-9]\d*)\.(0|[1-9]\d*)\.(0|[1-9]\d*)(?:-((?:0|[1-9]\d*|\d*[a-zA-Z-][0-9a-zA-Z-]*)(?:\.(?:0|[1-9]\d*|\d*[a-zA-Z-][0-9a-zA-Z-]*))*))?(?:\+([0-9a-zA-Z-]+(?:\.[0-9a-zA-Z-]+)*))?$`)
+
+//Version is a semantic version
+type Version struct {
+	Major      uint64
+	Minor      uint64
+	Patch      uint64
+	Prerelease []string
+	Build      string
+}
+
+//ParseVersion parses a tag as a semantic version
+func ParseVersion(tag string) (Version, bool) {
+	parts := semverRegex.FindStringSubmatch(tag)
+	if parts == nil {
+		return Version{}, false
+	}
+	var v Version
+	var err error
+	v.Major, err = strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return Version{}, false
+	}
+	v.Minor, err = strconv.ParseUint(parts[2], 10, 64)
+	if err != nil {
+		return Version{}, false
+	}
+	v.Patch, err = strconv.ParseUint(parts[3], 10, 64)
+	if err != nil {
+		return Version{}, false
+	}
+	if len(parts[4]) > 0 {
+		v.Prerelease = strings.Split(parts[4], ".")
+	}
+	v.Build = parts[5]
+	return v, true
+}
+
+//IsPrerelease checks if the version is a pre-release
+func (v Version) IsPrerelease() bool {
+	return len(v.Prerelease) > 0
+}
+
+//MinorVersion returns the major.minor of the version
+func (v Version) MinorVersion() string {
+	return fmt.Sprintf("%d.%d", v.Major, v.Minor)
+}
+
+//String returns the version without prefix
+func (v Version) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if v.IsPrerelease() {
+		s += "-" + strings.Join(v.Prerelease, ".")
+	}
+	if len(v.Build) > 0 {
+		s += "+" + v.Build
+	}
+	return s
+}
+
+//Compare compares versions precedence (-1 lower, 0 equal, 1 greater), build metadata is ignored
+func (v Version) Compare(o Version) int {
+	if c := compareUint(v.Major, o.Major); c != 0 {
+		return c
+	}
+	if c := compareUint(v.Minor, o.Minor); c != 0 {
+		return c
+	}
+	if c := compareUint(v.Patch, o.Patch); c != 0 {
+		return c
+	}
+	// a release has precedence over its pre-releases
+	switch {
+	case !v.IsPrerelease() && !o.IsPrerelease():
+		return 0
+	case !v.IsPrerelease():
+		return 1
+	case !o.IsPrerelease():
+		return -1
+	}
+	for i := 0; i < len(v.Prerelease) && i < len(o.Prerelease); i++ {
+		if c := compareIdentifier(v.Prerelease[i], o.Prerelease[i]); c != 0 {
+			return c
+		}
+	}
+	return compareUint(uint64(len(v.Prerelease)), uint64(len(o.Prerelease)))
+}
+
+// compare two unsigned integers
+func compareUint(a uint64, b uint64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// compare pre-release identifiers: numeric ones are lower than alphanumeric ones
+func compareIdentifier(a string, b string) int {
+	na, erra := strconv.ParseUint(a, 10, 64)
+	nb, errb := strconv.ParseUint(b, 10, 64)
+	switch {
+	case erra == nil && errb == nil:
+		return compareUint(na, nb)
+	case erra == nil:
+		return -1
+	case errb == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+// versioned image
+type versionedImage struct {
+	Image
+	Version Version
+}
+
+// get the highest version of the tags of images, images without version are ignored
+func versions(images []Image) []versionedImage {
+	var result []versionedImage
+	for _, image := range images {
+		found := false
+		var highest Version
+		for _, tag := range image.Tags {
+			v, ok := ParseVersion(tag)
+			if !ok {
+				continue
+			}
+			if !found || v.Compare(highest) > 0 {
+				highest = v
+				found = true
+			}
+		}
+		if found {
+			result = append(result, versionedImage{Image: image, Version: highest})
+		}
+	}
+	// order per precedence (higher to lower) then per date
+	sort.SliceStable(result, func(i, j int) bool {
+		c := result[i].Version.Compare(result[j].Version)
+		if c == 0 {
+			return result[i].Created.After(result[j].Created)
+		}
+		return c > 0
+	})
+	return result
+}
+
+// select the images to keep and to delete following version precedence
+func (r Rule) selectVersions(images []Image) ([]Image, []Image) {
+	versioned := versions(images)
+	reasons := make([]string, len(versioned))
+	// keep the highest versions
+	for i := 0; i < len(versioned) && i < r.Keep; i++ {
+		reasons[i] = fmt.Sprintf("within the %d highest versions", r.Keep)
+	}
+	// keep the latest patches of each minor and the newest release of each major
+	patches := map[string]int{}
+	majors := map[uint64]bool{}
+	for i, image := range versioned {
+		if image.Version.IsPrerelease() {
+			continue
+		}
+		minor := image.Version.MinorVersion()
+		if patches[minor] < r.KeepPatches {
+			patches[minor]++
+			if len(reasons[i]) == 0 {
+				reasons[i] = fmt.Sprintf("within the %d latest patches of %s", r.KeepPatches, minor)
+			}
+		}
+		if r.KeepMajors && !majors[image.Version.Major] {
+			majors[image.Version.Major] = true
+			if len(reasons[i]) == 0 {
+				reasons[i] = fmt.Sprintf("newest release of major %d", image.Version.Major)
+			}
+		}
+	}
+	treshold := time.Now().Add(-time.Duration(r.MaxAge))
+	prereleaseTreshold := time.Now().Add(-time.Duration(r.PrereleaseMaxAge))
+	var kept, deletions []Image
+	for i, image := range versioned {
+		// pre-releases superseded by a final release are deleted once old enough
+		if r.PrereleaseMaxAge > 0 && image.Version.IsPrerelease() && image.Created.Before(prereleaseTreshold) && released(versioned, image.Version) {
+			image.Reason = fmt.Sprintf("released pre-release older than %s", time.Duration(r.PrereleaseMaxAge))
+			deletions = append(deletions, image.Image)
+			continue
+		}
+		if len(reasons[i]) > 0 {
+			image.Reason = reasons[i]
+			kept = append(kept, image.Image)
+			continue
+		}
+		if image.Created.Before(treshold) {
+			image.Reason = r.expired()
+			deletions = append(deletions, image.Image)
+			continue
+		}
+		image.Reason = fmt.Sprintf("newer than %s", time.Duration(r.MaxAge))
+		kept = append(kept, image.Image)
+	}
+	return kept, deletions
+}
+
+// check if the final release of a pre-release exists (same major.minor.patch)
+func released(versioned []versionedImage, prerelease Version) bool {
+	for _, image := range versioned {
+		v := image.Version
+		if !v.IsPrerelease() && v.Major == prerelease.Major && v.Minor == prerelease.Minor && v.Patch == prerelease.Patch {
+			return true
+		}
+	}
+	return false
+}